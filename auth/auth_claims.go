@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+)
+
+// Claims describes the caller identified by the request's JWT
+type Claims struct {
+	UserID  string
+	IsAdmin bool
+}
+
+// Roles lists what the caller may act as, every caller is a user and admins are also admin
+func (c Claims) Roles() []string {
+	if c.IsAdmin {
+		return []string{"user", "admin"}
+	}
+	return []string{"user"}
+}
+
+// HasRole reports whether the caller may act as role
+func (c Claims) HasRole(role string) bool {
+	for _, r := range c.Roles() {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// FromContext reads the claims stored by the JWT middleware, returning empty claims when there is no token
+func FromContext(c echo.Context) Claims {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return Claims{}
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return Claims{}
+	}
+
+	return fromMapClaims(claims)
+}
+
+// ExpiresAt reads the exp claim of the request's JWT, reporting false when the token has none
+func ExpiresAt(c echo.Context) (time.Time, bool) {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(exp), 0), true
+}
+
+func fromMapClaims(claims jwt.MapClaims) Claims {
+	userID, _ := claims["user_id"].(string)
+	isAdmin, _ := claims["authorized"].(bool)
+	return Claims{UserID: userID, IsAdmin: isAdmin}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MetadataKey is the gRPC metadata key carrying "Bearer <jwt>", the same value as the X-Auth-Token header
+const MetadataKey = "x-auth-token"
+
+type claimsKey struct{}
+
+// NewContext returns ctx carrying claims
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromIncoming reads the claims stored by the gRPC interceptors, returning empty claims when there are none
+func FromIncoming(ctx context.Context) Claims {
+	claims, _ := ctx.Value(claimsKey{}).(Claims)
+	return claims
+}
+
+// verify checks the metadata token the way the Echo JWT middleware checks the header
+func verify(ctx context.Context, secret []byte) (Claims, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(MetadataKey)
+	if len(values) == 0 {
+		return Claims{}, status.Error(codes.Unauthenticated, "missing or malformed jwt")
+	}
+
+	parts := strings.SplitN(values[0], " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return Claims{}, status.Error(codes.Unauthenticated, "missing or malformed jwt")
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(parts[1], claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return secret, nil
+	})
+	if err != nil || !token.Valid {
+		return Claims{}, status.Error(codes.Unauthenticated, "invalid or expired jwt")
+	}
+
+	return fromMapClaims(claims), nil
+}
+
+// UnaryInterceptor rejects calls without a valid JWT and stores its claims in the context
+func UnaryInterceptor(secret []byte) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		claims, err := verify(ctx, secret)
+		if err != nil {
+			return nil, err
+		}
+		return handler(NewContext(ctx, claims), req)
+	}
+}
+
+// authStream swaps the context of a server stream for one carrying claims
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamInterceptor is UnaryInterceptor for streaming calls
+func StreamInterceptor(secret []byte) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		claims, err := verify(ss.Context(), secret)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authStream{ServerStream: ss, ctx: NewContext(ss.Context(), claims)})
+	}
+}
@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 type Properties struct {
 	AppPort             string `env:"APP_PORT" env-default:"8080"`
 	AppHost             string `env:"APP_HOST" env-default:"localhost"`
@@ -10,4 +12,65 @@ type Properties struct {
 	DBProductCollection string `env:"DB_PRODUCTS_COLLECTION" env-default:"products"`
 	DBUsersCollection   string `env:"DB_USERS_COLLECTION" env-default:"users"`
 	JwtSecret           string `env:"JWT_SECRET" env-default:"secretsecretsecret"`
+
+	DBPurchasesCollection        string        `env:"DB_PURCHASES_COLLECTION" env-default:"purchases"`
+	PurchaseLimitDefault         int           `env:"PURCHASE_LIMIT_DEFAULT" env-default:"5"`
+	PurchaseLimitWindow          time.Duration `env:"PURCHASE_LIMIT_WINDOW" env-default:"24h"`
+	DBPurchaseCountersCollection string        `env:"DB_PURCHASE_COUNTERS_COLLECTION" env-default:"purchase_counters"`
+
+	DBWishlistsCollection     string `env:"DB_WISHLISTS_COLLECTION" env-default:"wishlists"`
+	DBNotificationsCollection string `env:"DB_NOTIFICATIONS_COLLECTION" env-default:"notifications"`
+
+	DBPricesCollection string `env:"DB_PRICES_COLLECTION" env-default:"prices"`
+
+	FacetPriceBuckets []int `env:"FACET_PRICE_BUCKETS" env-default:"0,100,500,1000,2001"`
+
+	ReportsCacheTTL  time.Duration `env:"REPORTS_CACHE_TTL" env-default:"5m"`
+	ReportsCacheSize int           `env:"REPORTS_CACHE_SIZE" env-default:"1000"`
+
+	PublishInterval time.Duration `env:"PUBLISH_INTERVAL" env-default:"1m"`
+
+	CompareMax      int                `env:"COMPARE_MAX" env-default:"4"`
+	CompareCurrency string             `env:"COMPARE_CURRENCY" env-default:"USD"`
+	ExchangeRates   map[string]float64 `env:"EXCHANGE_RATES" env-default:"USD:1,EUR:0.85,GBP:0.73,INR:74.5,IDR:14500"`
+
+	RelatedIndexInterval    time.Duration `env:"RELATED_INDEX_INTERVAL" env-default:"10m"`
+	RelatedWeightVendor     float64       `env:"RELATED_WEIGHT_VENDOR" env-default:"3"`
+	RelatedWeightAccessory  float64       `env:"RELATED_WEIGHT_ACCESSORY" env-default:"1"`
+	RelatedWeightPriceBand  float64       `env:"RELATED_WEIGHT_PRICE_BAND" env-default:"2"`
+	RelatedWeightCoPurchase float64       `env:"RELATED_WEIGHT_CO_PURCHASE" env-default:"0.5"`
+	RelatedPriceBandRatio   float64       `env:"RELATED_PRICE_BAND_RATIO" env-default:"0.2"`
+
+	EventsHistory int `env:"EVENTS_HISTORY" env-default:"1000"`
+
+	WSSendBuffer     int           `env:"WS_SEND_BUFFER" env-default:"256"`
+	WSPingInterval   time.Duration `env:"WS_PING_INTERVAL" env-default:"30s"`
+	WSAllowedOrigins []string      `env:"WS_ALLOWED_ORIGINS" env-default:"http://localhost:8080"`
+
+	DBWebhooksCollection   string        `env:"DB_WEBHOOKS_COLLECTION" env-default:"webhooks"`
+	DBDeliveriesCollection string        `env:"DB_DELIVERIES_COLLECTION" env-default:"webhook_deliveries"`
+	WebhookMaxAttempts     int           `env:"WEBHOOK_MAX_ATTEMPTS" env-default:"5"`
+	WebhookBackoff         time.Duration `env:"WEBHOOK_BACKOFF" env-default:"2s"`
+	WebhookTimeout         time.Duration `env:"WEBHOOK_TIMEOUT" env-default:"10s"`
+	WebhookInterval        time.Duration `env:"WEBHOOK_INTERVAL" env-default:"1s"`
+	WebhookLock            time.Duration `env:"WEBHOOK_LOCK" env-default:"30s"`
+
+	DBOutboxCollection string        `env:"DB_OUTBOX_COLLECTION" env-default:"outbox"`
+	OutboxInterval     time.Duration `env:"OUTBOX_INTERVAL" env-default:"1s"`
+	OutboxBatch        int           `env:"OUTBOX_BATCH" env-default:"100"`
+	OutboxMaxAttempts  int           `env:"OUTBOX_MAX_ATTEMPTS" env-default:"10"`
+	OutboxLock         time.Duration `env:"OUTBOX_LOCK" env-default:"30s"`
+	OutboxFile         string        `env:"OUTBOX_FILE"`
+	OutboxWebhookURL   string        `env:"OUTBOX_WEBHOOK_URL"`
+
+	DBIdempotencyCollection string        `env:"DB_IDEMPOTENCY_COLLECTION" env-default:"idempotency_keys"`
+	IdempotencyTTL          time.Duration `env:"IDEMPOTENCY_TTL" env-default:"24h"`
+	IdempotencyLock         time.Duration `env:"IDEMPOTENCY_LOCK" env-default:"1m"`
+
+	GRPCPort string `env:"GRPC_PORT" env-default:"8081"`
+
+	DevMode bool `env:"DEV_MODE" env-default:"false"`
+
+	V1DeprecatedAt time.Time `env:"V1_DEPRECATED_AT" env-layout:"2006-01-02" env-default:"2026-11-01"`
+	V1SunsetAt     time.Time `env:"V1_SUNSET_AT" env-layout:"2006-01-02" env-default:"2027-05-01"`
 }
@@ -0,0 +1,71 @@
+package domainerr
+
+import "errors"
+
+// Kind says what went wrong in terms of the domain, transports map it to their own statuses
+type Kind int
+
+const (
+	// Internal is a failure the caller cannot fix, such as a database error
+	Internal Kind = iota
+	InvalidArgument
+	NotFound
+	Conflict
+	Unauthorized
+	Forbidden
+	// Unavailable is a dependency that is not ready yet, the caller may retry
+	Unavailable
+)
+
+var kindNames = map[Kind]string{
+	Internal:        "internal",
+	InvalidArgument: "invalid_argument",
+	NotFound:        "not_found",
+	Conflict:        "conflict",
+	Unauthorized:    "unauthorized",
+	Forbidden:       "forbidden",
+	Unavailable:     "unavailable",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Error is a failure of the product or user logic, Message is safe to show to clients
+// and Err is the cause kept for logs and for validator details
+type Error struct {
+	Kind    Kind
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap attaches the cause of the error
+func (e *Error) Wrap(err error) *Error {
+	e.Err = err
+	return e
+}
+
+// New is an error of the given kind, e.g. domainerr.New(domainerr.NotFound, "Product does not exists")
+func New(kind Kind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// KindOf is the kind of the first domain error in the chain of err, Internal when there is none
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Internal
+}
@@ -0,0 +1,57 @@
+package graph
+
+import (
+	"context"
+	"learning-golang-restful-api/auth"
+	"net/http"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+type GraphHandler struct {
+	Products ProductService
+	Users    UserService
+
+	once   sync.Once
+	schema graphql.Schema
+	err    error
+}
+
+// request is a GraphQL request as sent by POST or GET
+type request struct {
+	Query         string                 `json:"query" query:"query"`
+	OperationName string                 `json:"operationName" query:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func (h *GraphHandler) Query(c echo.Context) error {
+	h.once.Do(func() {
+		h.schema, h.err = newSchema(h.Products, h.Users)
+	})
+	if h.err != nil {
+		log.Errorf("Unable to build the schema: %v", h.err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to build the schema")
+	}
+
+	var req request
+	if err := c.Bind(&req); err != nil {
+		log.Errorf("Unable to bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to bind data")
+	}
+
+	ctx := withClaims(context.Background(), auth.FromContext(c))
+	ctx = withLoaders(ctx, newLoaders(h.Users))
+
+	res := graphql.Do(graphql.Params{
+		Schema:         h.schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
+	})
+
+	return c.JSON(http.StatusOK, res)
+}
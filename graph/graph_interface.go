@@ -0,0 +1,27 @@
+package graph
+
+import (
+	"context"
+	"io"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/products"
+	"learning-golang-restful-api/users"
+	"net/url"
+)
+
+type (
+	// ProductService is the product logic shared with the REST handlers
+	ProductService interface {
+		Create(ctx context.Context, products []products.Product, claims auth.Claims) ([]interface{}, *domainerr.Error)
+		Find(ctx context.Context, qs url.Values, claims auth.Claims) ([]products.Product, *domainerr.Error)
+		Get(ctx context.Context, id string, claims auth.Claims) (*products.Product, *domainerr.Error)
+		Update(ctx context.Context, id string, body io.ReadCloser, claims auth.Claims) (*products.Product, *domainerr.Error)
+		Delete(ctx context.Context, id string, claims auth.Claims) (int, *domainerr.Error)
+	}
+
+	// UserService reads users in batches
+	UserService interface {
+		FindByEmails(ctx context.Context, emails []string) ([]users.User, *domainerr.Error)
+	}
+)
@@ -0,0 +1,68 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"learning-golang-restful-api/users"
+
+	"github.com/graph-gophers/dataloader"
+)
+
+type loadersKey struct{}
+
+// loaders batch the lookups of one request, a list of products asks for its creators in one query
+type loaders struct {
+	users *dataloader.Loader
+}
+
+func newLoaders(userService UserService) *loaders {
+	return &loaders{users: dataloader.NewBatchedLoader(usersBatch(userService))}
+}
+
+// usersBatch loads users by username, a missing user resolves to nil
+func usersBatch(userService UserService) dataloader.BatchFunc {
+	return func(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+		results := make([]*dataloader.Result, len(keys))
+
+		found, domainErr := userService.FindByEmails(ctx, keys.Keys())
+		if domainErr != nil {
+			for i := range results {
+				results[i] = &dataloader.Result{Error: resolveError{domainErr}}
+			}
+			return results
+		}
+
+		byEmail := map[string]users.User{}
+		for _, user := range found {
+			byEmail[user.Email] = user
+		}
+		for i, key := range keys {
+			if user, ok := byEmail[key.String()]; ok {
+				results[i] = &dataloader.Result{Data: user}
+			} else {
+				results[i] = &dataloader.Result{}
+			}
+		}
+		return results
+	}
+}
+
+func withLoaders(ctx context.Context, l *loaders) context.Context {
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+// loadUser returns a thunk so GraphQL resolves every user of a level before the batch runs
+func loadUser(ctx context.Context, email string) (interface{}, error) {
+	l, ok := ctx.Value(loadersKey{}).(*loaders)
+	if !ok {
+		return nil, errors.New("loaders missing from the context")
+	}
+	if email == "" {
+		return nil, nil
+	}
+
+	thunk := l.users.Load(ctx, dataloader.StringKey(email))
+	return func() (interface{}, error) {
+		return thunk()
+	}, nil
+}
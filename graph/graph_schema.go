@@ -0,0 +1,252 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/problem"
+	"learning-golang-restful-api/products"
+	"learning-golang-restful-api/users"
+	"net/url"
+	"reflect"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+)
+
+type claimsKey struct{}
+
+// readOnly are the product fields the server keeps, they are left out of ProductInput
+var readOnly = map[string]bool{
+	"_id":            true,
+	"created_by":     true,
+	"updated_by":     true,
+	"created_at":     true,
+	"updated_at":     true,
+	"status_history": true,
+}
+
+// resolveError carries the HTTP status of the shared logic into the GraphQL error extensions
+type resolveError struct {
+	err *domainerr.Error
+}
+
+func (e resolveError) Error() string {
+	return e.err.Message
+}
+
+func (e resolveError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"status": problem.Status(e.err.Kind)}
+}
+
+func withClaims(ctx context.Context, claims auth.Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+func claimsFrom(ctx context.Context) auth.Claims {
+	claims, _ := ctx.Value(claimsKey{}).(auth.Claims)
+	return claims
+}
+
+// productBody turns mutation input into the JSON body the update logic decodes
+func productBody(input interface{}) (*products.Product, []byte, error) {
+	b, err := json.Marshal(input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var product products.Product
+	if err := json.Unmarshal(b, &product); err != nil {
+		return nil, nil, err
+	}
+	return &product, b, nil
+}
+
+// filterParams turns the filter and paging arguments into the query params GET /products takes
+func filterParams(args map[string]interface{}) url.Values {
+	qs := url.Values{}
+	if filter, ok := args["filter"].(map[string]interface{}); ok {
+		for k, v := range filter {
+			switch v := v.(type) {
+			case bool:
+				qs.Set(k, strconv.FormatBool(v))
+			default:
+				qs.Set(k, fmt.Sprint(v))
+			}
+		}
+	}
+
+	// one more than asked tells whether there is a next page
+	limit, _ := args["limit"].(int)
+	offset, _ := args["offset"].(int)
+	qs.Set("limit", strconv.Itoa(limit+1))
+	qs.Set("offset", strconv.Itoa(offset))
+	return qs
+}
+
+// ProductPage is one page of a product listing
+type ProductPage struct {
+	Items   []products.Product `json:"items"`
+	Offset  int                `json:"offset"`
+	Limit   int                `json:"limit"`
+	HasNext bool               `json:"has_next"`
+}
+
+// newSchema builds the schema from the Product and User structs and wires it to the shared logic
+func newSchema(productService ProductService, userService UserService) (graphql.Schema, error) {
+	b := newTypeBuilder()
+
+	userType := b.object(reflect.TypeOf(users.User{}), nil)
+	creator := func(field string) *graphql.Field {
+		return &graphql.Field{
+			Type: userType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				product := p.Source.(products.Product)
+				if field == "created_by" {
+					return loadUser(p.Context, product.CreatedBy)
+				}
+				return loadUser(p.Context, product.UpdatedBy)
+			},
+		}
+	}
+	productType := b.object(reflect.TypeOf(products.Product{}), graphql.Fields{
+		"creator": creator("created_by"),
+		"updater": creator("updated_by"),
+	})
+	productInput := b.inputObject(reflect.TypeOf(products.Product{}), readOnly)
+
+	// every top level string field filters like the query params of GET /products
+	filterFields := graphql.InputObjectConfigFieldMap{
+		"mine":          &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+		"updated_since": &graphql.InputObjectFieldConfig{Type: graphql.String},
+	}
+	for _, f := range structFields(reflect.TypeOf(products.Product{})) {
+		if f.typ.Kind() == reflect.String {
+			filterFields[f.name] = &graphql.InputObjectFieldConfig{Type: graphql.String}
+		}
+	}
+	productFilter := graphql.NewInputObject(graphql.InputObjectConfig{Name: "ProductFilter", Fields: filterFields})
+
+	pageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ProductPage",
+		Fields: graphql.Fields{
+			"items":    &graphql.Field{Type: graphql.NewList(productType)},
+			"offset":   &graphql.Field{Type: graphql.Int},
+			"limit":    &graphql.Field{Type: graphql.Int},
+			"has_next": &graphql.Field{Type: graphql.Boolean},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"products": &graphql.Field{
+				Type: pageType,
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: productFilter},
+					"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 20},
+					"offset": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					found, domainErr := productService.Find(p.Context, filterParams(p.Args), claimsFrom(p.Context))
+					if domainErr != nil {
+						return nil, resolveError{domainErr}
+					}
+
+					page := ProductPage{Items: found, Limit: p.Args["limit"].(int), Offset: p.Args["offset"].(int)}
+					if len(page.Items) > page.Limit {
+						page.Items = page.Items[:page.Limit]
+						page.HasNext = true
+					}
+					return page, nil
+				},
+			},
+			"product": &graphql.Field{
+				Type: productType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					product, domainErr := productService.Get(p.Context, p.Args["id"].(string), claimsFrom(p.Context))
+					if domainErr != nil {
+						return nil, resolveError{domainErr}
+					}
+					return *product, nil
+				},
+			},
+			"me": &graphql.Field{
+				Type: userType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return loadUser(p.Context, claimsFrom(p.Context).UserID)
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createProducts": &graphql.Field{
+				Type: graphql.NewList(graphql.ID),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(productInput)))},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					var created []products.Product
+					for _, input := range p.Args["input"].([]interface{}) {
+						product, _, err := productBody(input)
+						if err != nil {
+							return nil, err
+						}
+						created = append(created, *product)
+					}
+
+					ids, domainErr := productService.Create(p.Context, created, claimsFrom(p.Context))
+					if domainErr != nil {
+						return nil, resolveError{domainErr}
+					}
+					return ids, nil
+				},
+			},
+			"updateProduct": &graphql.Field{
+				Type: productType,
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(productInput)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					// only the given fields go into the body, the rest keeps its stored value
+					_, body, err := productBody(p.Args["input"])
+					if err != nil {
+						return nil, err
+					}
+
+					product, domainErr := productService.Update(p.Context, p.Args["id"].(string), ioutil.NopCloser(bytes.NewReader(body)), claimsFrom(p.Context))
+					if domainErr != nil {
+						return nil, resolveError{domainErr}
+					}
+					return *product, nil
+				},
+			},
+			"deleteProduct": &graphql.Field{
+				Type: graphql.Int,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					deleted, domainErr := productService.Delete(p.Context, p.Args["id"].(string), claimsFrom(p.Context))
+					if domainErr != nil {
+						return nil, resolveError{domainErr}
+					}
+					return deleted, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
@@ -0,0 +1,182 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/products"
+	"learning-golang-restful-api/users"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeProducts struct {
+	items   []products.Product
+	qs      url.Values
+	body    string
+	created []products.Product
+}
+
+func (f *fakeProducts) Create(ctx context.Context, created []products.Product, claims auth.Claims) ([]interface{}, *domainerr.Error) {
+	f.created = created
+	var ids []interface{}
+	for range created {
+		ids = append(ids, primitive.NewObjectID().Hex())
+	}
+	return ids, nil
+}
+
+func (f *fakeProducts) Find(ctx context.Context, qs url.Values, claims auth.Claims) ([]products.Product, *domainerr.Error) {
+	f.qs = qs
+	return f.items, nil
+}
+
+func (f *fakeProducts) Get(ctx context.Context, id string, claims auth.Claims) (*products.Product, *domainerr.Error) {
+	for _, product := range f.items {
+		if product.ID.Hex() == id {
+			return &product, nil
+		}
+	}
+	return nil, domainerr.New(domainerr.NotFound, "Product does not exists")
+}
+
+func (f *fakeProducts) Update(ctx context.Context, id string, body io.ReadCloser, claims auth.Claims) (*products.Product, *domainerr.Error) {
+	b, _ := ioutil.ReadAll(body)
+	f.body = string(b)
+	product, domainErr := f.Get(ctx, id, claims)
+	if domainErr != nil {
+		return nil, domainErr
+	}
+	json.Unmarshal(b, product)
+	return product, nil
+}
+
+func (f *fakeProducts) Delete(ctx context.Context, id string, claims auth.Claims) (int, *domainerr.Error) {
+	return 1, nil
+}
+
+type fakeUsers struct {
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (f *fakeUsers) FindByEmails(ctx context.Context, emails []string) ([]users.User, *domainerr.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, emails)
+
+	var found []users.User
+	for _, email := range emails {
+		found = append(found, users.User{Email: email, Password: "hashed"})
+	}
+	return found, nil
+}
+
+func query(t *testing.T, h *GraphHandler, q string) map[string]interface{} {
+	body, _ := json.Marshal(request{Query: q})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	res := httptest.NewRecorder()
+	c := echo.New().NewContext(req, res)
+
+	require.NoError(t, h.Query(c))
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	var out map[string]interface{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &out))
+	return out
+}
+
+func TestGraph(t *testing.T) {
+	first := products.Product{ID: primitive.NewObjectID(), Name: "iphone", Price: 1000, Vendor: "Apple", CreatedBy: "a@mail.com"}
+	second := products.Product{ID: primitive.NewObjectID(), Name: "alexa", Price: 100, Vendor: "Amazon", CreatedBy: "b@mail.com"}
+	third := products.Product{ID: primitive.NewObjectID(), Name: "echo", Price: 80, Vendor: "Amazon", CreatedBy: "a@mail.com"}
+
+	t.Run("Test schema follows the product and user structs", func(t *testing.T) {
+		h := &GraphHandler{Products: &fakeProducts{}, Users: &fakeUsers{}}
+		fieldNames := func(typeName string) []string {
+			out := query(t, h, `{ __type(name: "`+typeName+`") { fields { name } inputFields { name } } }`)
+			var names []string
+			typ := out["data"].(map[string]interface{})["__type"].(map[string]interface{})
+			for _, key := range []string{"fields", "inputFields"} {
+				list, _ := typ[key].([]interface{})
+				for _, f := range list {
+					names = append(names, f.(map[string]interface{})["name"].(string))
+				}
+			}
+			return names
+		}
+
+		names := fieldNames("Product")
+		assert.Contains(t, names, "name")
+		assert.Contains(t, names, "status_history")
+		assert.Contains(t, names, "creator")
+		assert.NotContains(t, fieldNames("ProductInput"), "created_by")
+		assert.NotContains(t, fieldNames("User"), "password")
+		assert.NotContains(t, fieldNames("User"), "is_admin")
+	})
+
+	t.Run("Test products page with creators batched", func(t *testing.T) {
+		fp := &fakeProducts{items: []products.Product{first, second, third}}
+		fu := &fakeUsers{}
+		h := &GraphHandler{Products: fp, Users: fu}
+
+		out := query(t, h, `{ products(filter: {vendor: "Amazon", mine: true}, limit: 2) { has_next items { _id name creator { username } } } }`)
+		require.Nil(t, out["errors"])
+
+		page := out["data"].(map[string]interface{})["products"].(map[string]interface{})
+		assert.Equal(t, true, page["has_next"])
+		items := page["items"].([]interface{})
+		require.Len(t, items, 2)
+		assert.Equal(t, first.ID.Hex(), items[0].(map[string]interface{})["_id"])
+		assert.Equal(t, "b@mail.com", items[1].(map[string]interface{})["creator"].(map[string]interface{})["username"])
+
+		assert.Equal(t, "Amazon", fp.qs.Get("vendor"))
+		assert.Equal(t, "true", fp.qs.Get("mine"))
+		assert.Equal(t, "3", fp.qs.Get("limit"))
+		assert.Len(t, fu.calls, 1)
+		assert.ElementsMatch(t, []string{"a@mail.com", "b@mail.com"}, fu.calls[0])
+	})
+
+	t.Run("Test not found keeps the status", func(t *testing.T) {
+		h := &GraphHandler{Products: &fakeProducts{}, Users: &fakeUsers{}}
+		out := query(t, h, `{ product(id: "`+primitive.NewObjectID().Hex()+`") { name } }`)
+
+		errs := out["errors"].([]interface{})
+		require.Len(t, errs, 1)
+		assert.Equal(t, "Product does not exists", errs[0].(map[string]interface{})["message"])
+		assert.Equal(t, float64(http.StatusNotFound), errs[0].(map[string]interface{})["extensions"].(map[string]interface{})["status"])
+	})
+
+	t.Run("Test mutations reuse the product logic", func(t *testing.T) {
+		fp := &fakeProducts{items: []products.Product{first}}
+		h := &GraphHandler{Products: fp, Users: &fakeUsers{}}
+
+		out := query(t, h, `mutation { updateProduct(id: "`+first.ID.Hex()+`", input: {price: 900}) { name price } }`)
+		require.Nil(t, out["errors"])
+		assert.JSONEq(t, `{"price": 900}`, fp.body)
+		updated := out["data"].(map[string]interface{})["updateProduct"].(map[string]interface{})
+		assert.Equal(t, "iphone", updated["name"])
+		assert.Equal(t, float64(900), updated["price"])
+
+		out = query(t, h, `mutation { createProducts(input: [{name: "pixel", price: 700, currency: "USD", vendor: "Google"}]) }`)
+		require.Nil(t, out["errors"])
+		require.Len(t, fp.created, 1)
+		assert.Equal(t, "pixel", fp.created[0].Name)
+
+		out = query(t, h, `mutation { createProducts(input: [{name: "pixel", created_by: "someone"}]) }`)
+		assert.NotNil(t, out["errors"])
+	})
+}
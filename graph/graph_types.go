@@ -0,0 +1,172 @@
+package graph
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+// structField is a struct field as GraphQL sees it, named after its json tag
+type structField struct {
+	name  string
+	index []int
+	typ   reflect.Type
+}
+
+// structFields lists the fields of t that have a json name and are not tagged graphql:"-"
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("graphql") == "-" {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{name: name, index: f.Index, typ: f.Type})
+	}
+	return fields
+}
+
+// typeBuilder turns DTO structs into GraphQL types, so the schema follows the structs instead of a hand kept copy
+type typeBuilder struct {
+	objects map[reflect.Type]*graphql.Object
+	inputs  map[reflect.Type]*graphql.InputObject
+}
+
+func newTypeBuilder() *typeBuilder {
+	return &typeBuilder{
+		objects: map[reflect.Type]*graphql.Object{},
+		inputs:  map[reflect.Type]*graphql.InputObject{},
+	}
+}
+
+// scalar maps a Go type to a GraphQL scalar, nil when it is not one
+func scalar(t reflect.Type) graphql.Type {
+	switch {
+	case t == timeType:
+		return graphql.DateTime
+	case t == objectIDType:
+		return graphql.ID
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return graphql.String
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return graphql.Int
+	case reflect.Float32, reflect.Float64:
+		return graphql.Float
+	case reflect.Bool:
+		return graphql.Boolean
+	}
+	return nil
+}
+
+// output returns the GraphQL output type of a Go type
+func (b *typeBuilder) output(t reflect.Type) graphql.Output {
+	if s := scalar(t); s != nil {
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.output(t.Elem())
+	case reflect.Slice:
+		return graphql.NewList(b.output(t.Elem()))
+	case reflect.Struct:
+		return b.object(t, nil)
+	}
+	return graphql.String
+}
+
+// object builds the object type of a struct, extra adds fields that do not come from the struct
+func (b *typeBuilder) object(t reflect.Type, extra graphql.Fields) *graphql.Object {
+	if obj, ok := b.objects[t]; ok {
+		return obj
+	}
+
+	fields := graphql.Fields{}
+	for _, f := range structFields(t) {
+		fields[f.name] = &graphql.Field{Type: b.output(f.typ), Resolve: resolveStructField(f.index)}
+	}
+	for name, field := range extra {
+		fields[name] = field
+	}
+
+	obj := graphql.NewObject(graphql.ObjectConfig{Name: t.Name(), Fields: fields})
+	b.objects[t] = obj
+	return obj
+}
+
+// input returns the GraphQL input type of a Go type
+func (b *typeBuilder) input(t reflect.Type) graphql.Input {
+	if s := scalar(t); s != nil {
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.input(t.Elem())
+	case reflect.Slice:
+		return graphql.NewList(b.input(t.Elem()))
+	case reflect.Struct:
+		return b.inputObject(t, nil)
+	}
+	return graphql.String
+}
+
+// inputObject builds the input type of a struct, leaving out the skip fields the server keeps itself
+func (b *typeBuilder) inputObject(t reflect.Type, skip map[string]bool) *graphql.InputObject {
+	if in, ok := b.inputs[t]; ok {
+		return in
+	}
+
+	fields := graphql.InputObjectConfigFieldMap{}
+	for _, f := range structFields(t) {
+		if skip[f.name] {
+			continue
+		}
+		fields[f.name] = &graphql.InputObjectFieldConfig{Type: b.input(f.typ)}
+	}
+
+	in := graphql.NewInputObject(graphql.InputObjectConfig{Name: t.Name() + "Input", Fields: fields})
+	b.inputs[t] = in
+	return in
+}
+
+// resolveStructField reads a field by index, ids as hex and nil pointers as null
+func resolveStructField(index []int) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		v := reflect.Indirect(reflect.ValueOf(p.Source))
+		if v.Kind() != reflect.Struct {
+			return nil, nil
+		}
+
+		f := v.FieldByIndex(index)
+		if f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				return nil, nil
+			}
+			f = f.Elem()
+		}
+		if id, ok := f.Interface().(primitive.ObjectID); ok {
+			return id.Hex(), nil
+		}
+		return f.Interface(), nil
+	}
+}
@@ -0,0 +1,92 @@
+package hypermedia
+
+const (
+	MIMEJSONAPI = "application/vnd.api+json"
+	MIMEHAL     = "application/hal+json"
+)
+
+// Format is the representation negotiated from the Accept header
+type Format int
+
+const (
+	// Plain is the JSON every handler answers with by default
+	Plain Format = iota
+	JSONAPI
+	HAL
+)
+
+// Links maps a relation name such as self or next to a URL
+type Links map[string]string
+
+// Resource is one object of a response, Attributes is marshalled without its id field
+type Resource struct {
+	Type          string
+	ID            string
+	Attributes    interface{}
+	Links         Links
+	Relationships map[string]Relationship
+}
+
+// Relationship points to related resources by type and id, their full objects go to Document.Included
+type Relationship struct {
+	Data   []Resource
+	ToMany bool
+	Links  Links
+}
+
+// Document is a whole response, rendered as JSON:API or HAL
+type Document struct {
+	Data []Resource
+	// Collection renders Data as a list even when it holds a single resource
+	Collection bool
+	// Name is the key of the collection embedded in HAL, e.g. products
+	Name     string
+	Included []Resource
+	Links    Links
+	Meta     map[string]interface{}
+}
+
+// ErrorObject is a JSON:API error
+type ErrorObject struct {
+	ID     string       `json:"id,omitempty"`
+	Status string       `json:"status"`
+	Title  string       `json:"title"`
+	Detail string       `json:"detail,omitempty"`
+	Source *ErrorSource `json:"source,omitempty"`
+}
+
+// ErrorSource tells which part of the request an error is about
+type ErrorSource struct {
+	Pointer   string `json:"pointer,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+}
+
+type jsonapiDocument struct {
+	Data     interface{}            `json:"data,omitempty"`
+	Included []jsonapiResource      `json:"included,omitempty"`
+	Links    Links                  `json:"links,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+	Errors   []ErrorObject          `json:"errors,omitempty"`
+}
+
+type jsonapiResource struct {
+	Type          string                         `json:"type"`
+	ID            string                         `json:"id"`
+	Attributes    map[string]interface{}         `json:"attributes,omitempty"`
+	Relationships map[string]jsonapiRelationship `json:"relationships,omitempty"`
+	Links         Links                          `json:"links,omitempty"`
+}
+
+type jsonapiRelationship struct {
+	Data  interface{} `json:"data"`
+	Links Links       `json:"links,omitempty"`
+}
+
+type identifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type halLink struct {
+	Href string `json:"href"`
+}
@@ -0,0 +1,54 @@
+package hypermedia
+
+import (
+	"encoding/json"
+	"learning-golang-restful-api/problem"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+)
+
+// ErrorHandler answers clients that asked for JSON:API with error objects and hands every other error to next
+func ErrorHandler(next echo.HTTPErrorHandler) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if c.Response().Committed || Negotiate(c) != JSONAPI {
+			next(err, c)
+			return
+		}
+
+		p := problem.New(c, err)
+		body, _ := json.Marshal(jsonapiDocument{Errors: errorObjects(p)})
+		c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+		if c.Request().Method == http.MethodHead {
+			err = c.NoContent(p.Status)
+		} else {
+			err = c.Blob(p.Status, MIMEJSONAPI, body)
+		}
+		if err != nil {
+			c.Echo().Logger.Error(err)
+		}
+	}
+}
+
+// errorObjects turns a problem into JSON:API errors, one error object per field error
+func errorObjects(p problem.Problem) []ErrorObject {
+	base := ErrorObject{ID: p.CorrelationID, Status: strconv.Itoa(p.Status), Title: p.Title, Detail: p.Detail}
+	if len(p.Errors) == 0 {
+		return []ErrorObject{base}
+	}
+
+	objects := make([]ErrorObject, 0, len(p.Errors))
+	for _, violation := range p.Errors {
+		object := base
+		object.Detail = violation.Reason
+		switch violation.In {
+		case "body":
+			object.Source = &ErrorSource{Pointer: "/" + violation.Field}
+		case "query", "path", "header":
+			object.Source = &ErrorSource{Parameter: violation.Field}
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
@@ -0,0 +1,208 @@
+package hypermedia
+
+import (
+	"encoding/json"
+	"learning-golang-restful-api/negotiation"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// offers are the representations Render can write, plain JSON first as the default
+var offers = append([]string{negotiation.MIMEJSON, MIMEJSONAPI, MIMEHAL}, negotiation.Offers[1:]...)
+
+// Negotiate picks the format with the highest quality in Accept, the first listed wins ties
+func Negotiate(c echo.Context) Format {
+	mimeType, _ := negotiation.Negotiate(c.Request().Header.Get(echo.HeaderAccept), offers)
+	switch mimeType {
+	case MIMEJSONAPI:
+		return JSONAPI
+	case MIMEHAL:
+		return HAL
+	}
+	return Plain
+}
+
+// Acceptable answers 406 before the handler runs when Accept rules out every representation
+func Acceptable(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, ok := negotiation.Negotiate(c.Request().Header.Get(echo.HeaderAccept), offers); !ok {
+			c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+			return echo.NewHTTPError(http.StatusNotAcceptable, "Unable to answer in any of the accepted media types")
+		}
+		return next(c)
+	}
+}
+
+// Render writes doc as JSON:API or HAL when the client asked for either, and plain in JSON, XML,
+// MessagePack or CSV otherwise
+func Render(c echo.Context, status int, doc Document, plain interface{}) error {
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+	mimeType, ok := negotiation.Negotiate(c.Request().Header.Get(echo.HeaderAccept), offers)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotAcceptable, "Unable to answer in any of the accepted media types")
+	}
+
+	var body interface{}
+	switch mimeType {
+	case MIMEJSONAPI:
+		body = toJSONAPI(doc)
+	case MIMEHAL:
+		body = toHAL(doc)
+	default:
+		return negotiation.Blob(c, status, mimeType, plain)
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		log.Errorf("Unable to encode the response: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to encode the response")
+	}
+	return c.Blob(status, mimeType, b)
+}
+
+// attributes turns a DTO into its JSON fields, the id lives outside the attributes
+func attributes(v interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if v == nil {
+		return fields
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("Unable to encode attributes: %v", err)
+		return fields
+	}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		log.Errorf("Unable to decode attributes: %v", err)
+	}
+	delete(fields, "id")
+	delete(fields, "_id")
+	return fields
+}
+
+func toJSONAPI(doc Document) jsonapiDocument {
+	out := jsonapiDocument{Links: doc.Links, Meta: doc.Meta}
+
+	if doc.Collection {
+		data := make([]jsonapiResource, 0, len(doc.Data))
+		for _, resource := range doc.Data {
+			data = append(data, jsonapiObject(resource))
+		}
+		out.Data = data
+	} else if len(doc.Data) > 0 {
+		out.Data = jsonapiObject(doc.Data[0])
+	}
+
+	for _, resource := range doc.Included {
+		out.Included = append(out.Included, jsonapiObject(resource))
+	}
+	return out
+}
+
+func jsonapiObject(resource Resource) jsonapiResource {
+	object := jsonapiResource{
+		Type:       resource.Type,
+		ID:         resource.ID,
+		Attributes: attributes(resource.Attributes),
+		Links:      resource.Links,
+	}
+
+	for name, rel := range resource.Relationships {
+		ids := make([]identifier, 0, len(rel.Data))
+		for _, related := range rel.Data {
+			ids = append(ids, identifier{Type: related.Type, ID: related.ID})
+		}
+
+		relationship := jsonapiRelationship{Links: rel.Links}
+		switch {
+		case rel.ToMany:
+			relationship.Data = ids
+		case len(ids) > 0:
+			relationship.Data = ids[0]
+		}
+		if object.Relationships == nil {
+			object.Relationships = map[string]jsonapiRelationship{}
+		}
+		object.Relationships[name] = relationship
+	}
+	return object
+}
+
+func halLinks(links Links) map[string]interface{} {
+	out := map[string]interface{}{}
+	for rel, href := range links {
+		out[rel] = halLink{Href: href}
+	}
+	return out
+}
+
+func toHAL(doc Document) map[string]interface{} {
+	included := map[identifier]Resource{}
+	for _, resource := range doc.Included {
+		included[identifier{Type: resource.Type, ID: resource.ID}] = resource
+	}
+
+	var out map[string]interface{}
+	if doc.Collection {
+		items := make([]map[string]interface{}, 0, len(doc.Data))
+		for _, resource := range doc.Data {
+			items = append(items, halObject(resource, included))
+		}
+		name := doc.Name
+		if name == "" {
+			name = "items"
+		}
+		out = map[string]interface{}{"_embedded": map[string]interface{}{name: items}}
+		if len(doc.Links) > 0 {
+			out["_links"] = halLinks(doc.Links)
+		}
+	} else if len(doc.Data) > 0 {
+		out = halObject(doc.Data[0], included)
+	} else {
+		out = map[string]interface{}{}
+		if len(doc.Links) > 0 {
+			out["_links"] = halLinks(doc.Links)
+		}
+	}
+
+	for key, value := range doc.Meta {
+		out[key] = value
+	}
+	return out
+}
+
+// halObject embeds the related resources that were included, the others stay links
+func halObject(resource Resource, included map[identifier]Resource) map[string]interface{} {
+	out := attributes(resource.Attributes)
+	links := halLinks(resource.Links)
+	embedded := map[string]interface{}{}
+
+	for name, rel := range resource.Relationships {
+		if related, ok := rel.Links["related"]; ok {
+			links[name] = halLink{Href: related}
+		}
+
+		var objects []map[string]interface{}
+		for _, ref := range rel.Data {
+			if full, ok := included[identifier{Type: ref.Type, ID: ref.ID}]; ok {
+				objects = append(objects, halObject(full, nil))
+			}
+		}
+		switch {
+		case len(objects) == 0:
+		case rel.ToMany:
+			embedded[name] = objects
+		default:
+			embedded[name] = objects[0]
+		}
+	}
+
+	if len(links) > 0 {
+		out["_links"] = links
+	}
+	if len(embedded) > 0 {
+		out["_embedded"] = embedded
+	}
+	return out
+}
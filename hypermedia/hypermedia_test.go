@@ -0,0 +1,152 @@
+package hypermedia
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	ID    string `json:"_id"`
+	Name  string `json:"name"`
+	Price int    `json:"price"`
+}
+
+func context(accept string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodGet, "/products", nil)
+	req.Header.Set(echo.HeaderAccept, accept)
+	res := httptest.NewRecorder()
+	return echo.New().NewContext(req, res), res
+}
+
+func document() Document {
+	vendor := Resource{Type: "vendors", ID: "Amazon", Attributes: map[string]string{"name": "Amazon"}, Links: Links{"related": "/products?vendor=Amazon"}}
+	return Document{
+		Collection: true,
+		Name:       "products",
+		Data: []Resource{{
+			Type:       "products",
+			ID:         "1",
+			Attributes: item{ID: "1", Name: "alexa", Price: 100},
+			Links:      Links{"self": "/products/1"},
+			Relationships: map[string]Relationship{
+				"vendor": {Data: []Resource{vendor}, Links: vendor.Links},
+			},
+		}},
+		Included: []Resource{vendor},
+		Links:    Links{"self": "/products?limit=1", "next": "/products?limit=1&offset=1"},
+	}
+}
+
+func decode(t *testing.T, res *httptest.ResponseRecorder) map[string]interface{} {
+	var body map[string]interface{}
+	require.Nil(t, json.Unmarshal(res.Body.Bytes(), &body))
+	return body
+}
+
+func TestHypermedia(t *testing.T) {
+	t.Run("Test negotiation", func(t *testing.T) {
+		for accept, format := range map[string]Format{
+			"":                                   Plain,
+			"application/json":                   Plain,
+			MIMEJSONAPI:                          JSONAPI,
+			MIMEHAL + ", application/json":       HAL,
+			"application/json;q=0.5, " + MIMEHAL: HAL,
+			MIMEHAL + ";q=0.1, */*":              Plain,
+		} {
+			c, _ := context(accept)
+			assert.Equal(t, format, Negotiate(c), accept)
+		}
+	})
+
+	t.Run("Test plain clients get the plain body", func(t *testing.T) {
+		c, res := context("application/json")
+		assert.Nil(t, Render(c, http.StatusOK, document(), []item{{Name: "alexa"}}))
+		assert.Equal(t, echo.MIMEApplicationJSONCharsetUTF8, res.Header().Get(echo.HeaderContentType))
+		assert.Contains(t, res.Body.String(), `"name":"alexa"`)
+	})
+
+	t.Run("Test other media types get the plain body", func(t *testing.T) {
+		c, res := context("text/csv, application/json;q=0.5")
+		require.Nil(t, Render(c, http.StatusOK, document(), []item{{ID: "1", Name: "alexa", Price: 100}}))
+		assert.Equal(t, "text/csv; charset=UTF-8", res.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, "_id,name,price\n1,alexa,100\n", res.Body.String())
+	})
+
+	t.Run("Test nothing acceptable", func(t *testing.T) {
+		c, _ := context("image/png")
+		err := Acceptable(func(c echo.Context) error { return nil })(c)
+		require.NotNil(t, err)
+		assert.Equal(t, http.StatusNotAcceptable, err.(*echo.HTTPError).Code)
+	})
+
+	t.Run("Test JSON:API document", func(t *testing.T) {
+		c, res := context(MIMEJSONAPI)
+		assert.Nil(t, Render(c, http.StatusOK, document(), nil))
+		assert.Equal(t, MIMEJSONAPI, res.Header().Get(echo.HeaderContentType))
+
+		body := decode(t, res)
+		data := body["data"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "products", data["type"])
+		assert.Equal(t, "1", data["id"])
+		assert.Equal(t, map[string]interface{}{"name": "alexa", "price": float64(100)}, data["attributes"])
+		assert.Equal(t, "/products/1", data["links"].(map[string]interface{})["self"])
+
+		vendor := data["relationships"].(map[string]interface{})["vendor"].(map[string]interface{})
+		assert.Equal(t, map[string]interface{}{"type": "vendors", "id": "Amazon"}, vendor["data"])
+		assert.Len(t, body["included"], 1)
+		assert.Equal(t, "/products?limit=1&offset=1", body["links"].(map[string]interface{})["next"])
+	})
+
+	t.Run("Test HAL document", func(t *testing.T) {
+		c, res := context(MIMEHAL)
+		assert.Nil(t, Render(c, http.StatusOK, document(), nil))
+		assert.Equal(t, MIMEHAL, res.Header().Get(echo.HeaderContentType))
+
+		body := decode(t, res)
+		assert.Equal(t, map[string]interface{}{"href": "/products?limit=1&offset=1"}, body["_links"].(map[string]interface{})["next"])
+
+		product := body["_embedded"].(map[string]interface{})["products"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "alexa", product["name"])
+		links := product["_links"].(map[string]interface{})
+		assert.Equal(t, map[string]interface{}{"href": "/products/1"}, links["self"])
+		assert.Equal(t, map[string]interface{}{"href": "/products?vendor=Amazon"}, links["vendor"])
+		assert.Equal(t, "Amazon", product["_embedded"].(map[string]interface{})["vendor"].(map[string]interface{})["name"])
+	})
+
+	t.Run("Test JSON:API error objects", func(t *testing.T) {
+		c, res := context(MIMEJSONAPI)
+		c.Request().Header.Set("X-Correlation-Id", "abc")
+		ErrorHandler(c.Echo().DefaultHTTPErrorHandler)(echo.NewHTTPError(http.StatusNotFound, "Product does not exists"), c)
+
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		assert.Equal(t, MIMEJSONAPI, res.Header().Get(echo.HeaderContentType))
+		assert.JSONEq(t, `{"errors": [{"id": "abc", "status": "404", "title": "Not Found", "detail": "Product does not exists"}]}`, res.Body.String())
+	})
+
+	t.Run("Test violations become one error object each", func(t *testing.T) {
+		c, res := context(MIMEJSONAPI)
+		message := echo.Map{"message": "Request does not match", "errors": []echo.Map{
+			{"in": "path", "field": "id", "reason": "bad id"},
+			{"in": "body", "field": "0/name", "reason": "too long"},
+		}}
+		ErrorHandler(c.Echo().DefaultHTTPErrorHandler)(echo.NewHTTPError(http.StatusBadRequest, message), c)
+
+		var body jsonapiDocument
+		require.Nil(t, json.Unmarshal(res.Body.Bytes(), &body))
+		require.Len(t, body.Errors, 2)
+		assert.Equal(t, &ErrorSource{Parameter: "id"}, body.Errors[0].Source)
+		assert.Equal(t, &ErrorSource{Pointer: "/0/name"}, body.Errors[1].Source)
+	})
+
+	t.Run("Test other clients keep the default errors", func(t *testing.T) {
+		c, res := context("application/json")
+		ErrorHandler(c.Echo().DefaultHTTPErrorHandler)(echo.NewHTTPError(http.StatusNotFound, "Product does not exists"), c)
+		assert.JSONEq(t, `{"message": "Product does not exists"}`, res.Body.String())
+	})
+}
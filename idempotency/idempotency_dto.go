@@ -0,0 +1,20 @@
+package idempotency
+
+import "time"
+
+// Record holds an Idempotency-Key of a user, the response is set once the first request completed
+type Record struct {
+	UserID string `bson:"user_id"`
+	Key    string `bson:"key"`
+	// Fingerprint hashes the request so a key reused for another request is rejected
+	Fingerprint string `bson:"fingerprint"`
+	// Token identifies the request holding the key, a request whose lock expired cannot overwrite its successor
+	Token     string              `bson:"token"`
+	Completed bool                `bson:"completed"`
+	Status    int                 `bson:"status,omitempty"`
+	Header    map[string][]string `bson:"header,omitempty"`
+	Body      []byte              `bson:"body,omitempty"`
+	CreatedAt time.Time           `bson:"created_at"`
+	// ExpiresAt is watched by the TTL index, it is the end of the lock until the record completes
+	ExpiresAt time.Time `bson:"expires_at"`
+}
@@ -0,0 +1,25 @@
+package idempotency
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// CollectionAPI collection interface
+	CollectionAPI interface {
+		InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+		FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+		UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+		DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	}
+
+	// Records keeps the keys in use, Begin returns the record already holding the key when the caller did not get it
+	Records interface {
+		Begin(ctx context.Context, record Record) (Record, bool, error)
+		Complete(ctx context.Context, record Record) error
+		Release(ctx context.Context, record Record) error
+	}
+)
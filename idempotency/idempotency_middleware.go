@@ -0,0 +1,149 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"learning-golang-restful-api/auth"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"github.com/labstack/gommon/random"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderReplayed is set on responses replayed from a record
+	HeaderReplayed = "Idempotent-Replayed"
+	MaxKeyLength   = 255
+)
+
+// perRequest headers are not stored, a replay keeps the ones of the retry.
+// Tokens are never kept, a replayed registration answers without one and the client logs in
+var perRequest = map[string]bool{
+	"X-Correlation-Id": true,
+	"X-Auth-Token":     true,
+}
+
+// Guard replays the first response to the requests retried with the same Idempotency-Key,
+// it goes after the JWT middleware as keys are per user, public routes share the keys of the empty user
+// so clients should send random keys such as UUIDs
+type Guard struct {
+	Records Records
+	// TTL is how long a response is replayed
+	TTL time.Duration
+	// Lock is how long a request holds its key, a request that crashed frees it once the lock expires
+	Lock time.Duration
+}
+
+// recorder copies what the handler writes
+type recorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Middleware runs the first request with a key and replays its response to the retries,
+// requests without the header are not affected
+func (g *Guard) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(HeaderIdempotencyKey)
+		if key == "" {
+			return next(c)
+		}
+		if len(key) > MaxKeyLength {
+			return echo.NewHTTPError(http.StatusBadRequest, "Idempotency-Key is too long")
+		}
+
+		body, err := ioutil.ReadAll(c.Request().Body)
+		if err != nil {
+			return err
+		}
+		c.Request().Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		now := time.Now().UTC()
+		record := Record{
+			UserID:      auth.FromContext(c).UserID,
+			Key:         key,
+			Fingerprint: fingerprint(c.Request(), body),
+			Token:       random.String(16),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(g.Lock),
+		}
+
+		existing, ok, err := g.Records.Begin(c.Request().Context(), record)
+		if err != nil {
+			log.Errorf("Unable to claim the idempotency key: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Unable to claim the idempotency key")
+		}
+		if !ok {
+			return replay(c, record, existing)
+		}
+
+		res := c.Response()
+		rec := &recorder{ResponseWriter: res.Writer}
+		res.Writer = rec
+		err = next(c)
+		res.Writer = rec.ResponseWriter
+
+		// the record is saved even when the client went away, its retry must not run again
+		ctx := context.Background()
+		if err != nil || res.Status >= http.StatusInternalServerError {
+			// failures are not replayed, the retry runs again
+			if releaseErr := g.Records.Release(ctx, record); releaseErr != nil {
+				log.Errorf("Unable to release the idempotency key: %v", releaseErr)
+			}
+			return err
+		}
+
+		record.Completed = true
+		record.Status = res.Status
+		record.Header = map[string][]string{}
+		for name, values := range res.Header() {
+			if !perRequest[name] {
+				record.Header[name] = values
+			}
+		}
+		record.Body = rec.body.Bytes()
+		record.ExpiresAt = time.Now().UTC().Add(g.TTL)
+		if err := g.Records.Complete(ctx, record); err != nil {
+			log.Errorf("Unable to store the idempotent response: %v", err)
+		}
+		return nil
+	}
+}
+
+// replay answers a request whose key is held by existing
+func replay(c echo.Context, record, existing Record) error {
+	if existing.Fingerprint != record.Fingerprint {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Idempotency-Key was used for another request")
+	}
+	if !existing.Completed {
+		return echo.NewHTTPError(http.StatusConflict, "A request with this Idempotency-Key is in progress")
+	}
+
+	header := c.Response().Header()
+	for name, values := range existing.Header {
+		header[name] = values
+	}
+	header.Set(HeaderReplayed, "true")
+	c.Response().WriteHeader(existing.Status)
+	_, err := c.Response().Write(existing.Body)
+	return err
+}
+
+// fingerprint hashes what makes a request, the same key on another route or with another body differs
+func fingerprint(req *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.Path + "\n" + req.Header.Get(echo.HeaderContentType) + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
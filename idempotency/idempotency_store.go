@@ -0,0 +1,66 @@
+package idempotency
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Store keeps the records in a collection with a unique index on user_id and key
+// and a TTL index on expires_at
+type Store struct {
+	Coll CollectionAPI
+}
+
+// Begin inserts the record, or takes over the key when the request holding it let its lock expire
+func (s *Store) Begin(ctx context.Context, record Record) (Record, bool, error) {
+	_, err := s.Coll.InsertOne(ctx, record)
+	if err == nil {
+		return record, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return Record{}, false, err
+	}
+
+	// the TTL monitor only runs every minute, an expired lock may still be there
+	res, err := s.Coll.UpdateOne(ctx, bson.M{
+		"user_id":    record.UserID,
+		"key":        record.Key,
+		"completed":  false,
+		"expires_at": bson.M{"$lte": record.CreatedAt},
+	}, bson.M{"$set": record})
+	if err != nil {
+		return Record{}, false, err
+	}
+	if res.MatchedCount == 1 {
+		return record, true, nil
+	}
+
+	var existing Record
+	err = s.Coll.FindOne(ctx, bson.M{"user_id": record.UserID, "key": record.Key}).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		// expired in between
+		return s.Begin(ctx, record)
+	}
+	if err != nil {
+		return Record{}, false, err
+	}
+	return existing, false, nil
+}
+
+// Complete stores the response of the request holding the key
+func (s *Store) Complete(ctx context.Context, record Record) error {
+	_, err := s.Coll.UpdateOne(ctx, owner(record), bson.M{"$set": record})
+	return err
+}
+
+// Release frees the key so the request can be retried
+func (s *Store) Release(ctx context.Context, record Record) error {
+	_, err := s.Coll.DeleteOne(ctx, owner(record))
+	return err
+}
+
+func owner(record Record) bson.M {
+	return bson.M{"user_id": record.UserID, "key": record.Key, "token": record.Token}
+}
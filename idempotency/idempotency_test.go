@@ -0,0 +1,207 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"learning-golang-restful-api/config"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// memory keeps records the way Store does, without expiry
+type memory map[string]Record
+
+func (m memory) Begin(ctx context.Context, record Record) (Record, bool, error) {
+	if existing, ok := m[record.UserID+"/"+record.Key]; ok {
+		return existing, false, nil
+	}
+	m[record.UserID+"/"+record.Key] = record
+	return record, true, nil
+}
+
+func (m memory) Complete(ctx context.Context, record Record) error {
+	m[record.UserID+"/"+record.Key] = record
+	return nil
+}
+
+func (m memory) Release(ctx context.Context, record Record) error {
+	delete(m, record.UserID+"/"+record.Key)
+	return nil
+}
+
+func post(h echo.HandlerFunc, key, body string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	res := httptest.NewRecorder()
+	c := echo.New().NewContext(req, res)
+	// every request gets its own correlation id
+	requests++
+	c.Response().Header().Set("X-Correlation-Id", strconv.Itoa(requests))
+	return res, h(c)
+}
+
+var requests int
+
+func TestGuard(t *testing.T) {
+	created := 0
+	create := func(c echo.Context) error {
+		created++
+		c.Response().Header().Set(echo.HeaderLocation, "/products/1")
+		c.Response().Header().Set("X-Auth-Token", "Bearer token")
+		return c.JSON(http.StatusCreated, []string{"1"})
+	}
+
+	t.Run("Test retries replay the first response", func(t *testing.T) {
+		created = 0
+		h := (&Guard{Records: memory{}, TTL: time.Hour, Lock: time.Minute}).Middleware(create)
+
+		first, err := post(h, "k1", `{"name":"alexa"}`)
+		require.Nil(t, err)
+		retry, err := post(h, "k1", `{"name":"alexa"}`)
+		require.Nil(t, err)
+
+		assert.Equal(t, 1, created)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, "/products/1", retry.Header().Get(echo.HeaderLocation))
+		assert.Empty(t, retry.Header().Get("X-Auth-Token"))
+		assert.Equal(t, "true", retry.Header().Get(HeaderReplayed))
+		assert.Empty(t, first.Header().Get(HeaderReplayed))
+		// the retry keeps its own correlation id
+		assert.NotEqual(t, first.Header().Get("X-Correlation-Id"), retry.Header().Get("X-Correlation-Id"))
+	})
+
+	t.Run("Test requests without a key are not affected", func(t *testing.T) {
+		created = 0
+		h := (&Guard{Records: memory{}}).Middleware(create)
+		post(h, "", `{}`)
+		post(h, "", `{}`)
+		assert.Equal(t, 2, created)
+	})
+
+	t.Run("Test another body under the same key", func(t *testing.T) {
+		h := (&Guard{Records: memory{}, TTL: time.Hour}).Middleware(create)
+		post(h, "k1", `{"name":"alexa"}`)
+		_, err := post(h, "k1", `{"name":"echo"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, err.(*echo.HTTPError).Code)
+	})
+
+	t.Run("Test a request in progress", func(t *testing.T) {
+		records := memory{}
+		var retry error
+		h := (&Guard{Records: records, TTL: time.Hour, Lock: time.Minute}).Middleware(func(c echo.Context) error {
+			_, retry = post((&Guard{Records: records}).Middleware(create), "k1", `{}`)
+			return c.NoContent(http.StatusCreated)
+		})
+		post(h, "k1", `{}`)
+		assert.Equal(t, http.StatusConflict, retry.(*echo.HTTPError).Code)
+	})
+
+	t.Run("Test failures are not replayed", func(t *testing.T) {
+		records := memory{}
+		h := (&Guard{Records: records}).Middleware(func(c echo.Context) error {
+			return errors.New("connection refused")
+		})
+		_, err := post(h, "k1", `{}`)
+		assert.NotNil(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("Test keys are bounded", func(t *testing.T) {
+		h := (&Guard{Records: memory{}}).Middleware(create)
+		_, err := post(h, strings.Repeat("k", MaxKeyLength+1), `{}`)
+		assert.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code)
+	})
+}
+
+// TestStore needs the Mongo server of the config, like the handler tests of the other packages
+func TestStore(t *testing.T) {
+	var cfg config.Properties
+	require.Nil(t, cleanenv.ReadEnv(&cfg))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%s", cfg.DBHost, cfg.DBPort)))
+	require.Nil(t, err)
+	coll := client.Database(cfg.DBName).Collection(cfg.DBIdempotencyCollection + "_test")
+	defer coll.Drop(context.Background())
+
+	_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	require.Nil(t, err)
+
+	store := &Store{Coll: coll}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	record := func(key, token string, expiresAt time.Time) Record {
+		return Record{UserID: "buyer@gmail.com", Key: key, Fingerprint: "f", Token: token, CreatedAt: now, ExpiresAt: expiresAt}
+	}
+
+	t.Run("Test the first request holds the key", func(t *testing.T) {
+		_, ok, err := store.Begin(ctx, record("k1", "first", now.Add(time.Minute)))
+		require.Nil(t, err)
+		assert.True(t, ok)
+
+		existing, ok, err := store.Begin(ctx, record("k1", "second", now.Add(time.Minute)))
+		require.Nil(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, "first", existing.Token)
+	})
+
+	t.Run("Test only the holder completes the key", func(t *testing.T) {
+		stale := record("k1", "second", now.Add(time.Hour))
+		stale.Completed = true
+		require.Nil(t, store.Complete(ctx, stale))
+		existing, _, _ := store.Begin(ctx, record("k1", "third", now.Add(time.Minute)))
+		assert.False(t, existing.Completed)
+
+		done := record("k1", "first", now.Add(time.Hour))
+		done.Completed, done.Status, done.Body = true, http.StatusCreated, []byte(`["1"]`)
+		require.Nil(t, store.Complete(ctx, done))
+		existing, ok, _ := store.Begin(ctx, record("k1", "third", now.Add(time.Minute)))
+		assert.False(t, ok)
+		assert.True(t, existing.Completed)
+		assert.Equal(t, []byte(`["1"]`), existing.Body)
+	})
+
+	t.Run("Test an expired lock is taken over", func(t *testing.T) {
+		_, ok, err := store.Begin(ctx, record("k2", "crashed", now.Add(-time.Second)))
+		require.Nil(t, err)
+		require.True(t, ok)
+
+		taken, ok, err := store.Begin(ctx, record("k2", "retry", now.Add(time.Minute)))
+		require.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "retry", taken.Token)
+
+		// the crashed request can no longer release its successor's key
+		require.Nil(t, store.Release(ctx, record("k2", "crashed", now)))
+		existing, ok, _ := store.Begin(ctx, record("k2", "other", now.Add(time.Minute)))
+		assert.False(t, ok)
+		assert.Equal(t, "retry", existing.Token)
+	})
+
+	t.Run("Test a released key is free again", func(t *testing.T) {
+		require.Nil(t, store.Release(ctx, record("k2", "retry", now)))
+		_, ok, err := store.Begin(ctx, record("k2", "again", now.Add(time.Minute)))
+		require.Nil(t, err)
+		assert.True(t, ok)
+	})
+}
@@ -3,11 +3,26 @@ package main
 import (
 	"context"
 	"fmt"
+	"learning-golang-restful-api/auth"
 	"learning-golang-restful-api/config"
+	"learning-golang-restful-api/graph"
+	"learning-golang-restful-api/hypermedia"
+	"learning-golang-restful-api/idempotency"
+	"learning-golang-restful-api/openapi"
+	"learning-golang-restful-api/outbox"
+	"learning-golang-restful-api/problem"
 	"learning-golang-restful-api/products"
+	"learning-golang-restful-api/productspb"
+	"learning-golang-restful-api/purchases"
+	"learning-golang-restful-api/realtime"
+	"learning-golang-restful-api/reports"
 	"learning-golang-restful-api/users"
+	"learning-golang-restful-api/webhooks"
+	"learning-golang-restful-api/wishlists"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/ilyakaznacheev/cleanenv"
@@ -18,16 +33,26 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc"
 )
 
 var (
-	C              *mongo.Client
-	db             *mongo.Database
-	cfg            config.Properties
-	productsColl   *mongo.Collection
-	usersColl      *mongo.Collection
-	XCorrelationId = "X-Correlation-Id"
-	XAuthToken     = "X-Auth-Token"
+	C               *mongo.Client
+	db              *mongo.Database
+	cfg             config.Properties
+	productsColl    *mongo.Collection
+	usersColl       *mongo.Collection
+	purchasesColl   *mongo.Collection
+	countersColl    *mongo.Collection
+	wishlistsColl   *mongo.Collection
+	notifyColl      *mongo.Collection
+	pricesColl      *mongo.Collection
+	webhooksColl    *mongo.Collection
+	deliveriesColl  *mongo.Collection
+	outboxColl      *mongo.Collection
+	idempotencyColl *mongo.Collection
+	XCorrelationId  = "X-Correlation-Id"
+	XAuthToken      = "X-Auth-Token"
 )
 
 func init() {
@@ -45,10 +70,19 @@ func init() {
 	db = C.Database(cfg.DBName)
 	productsColl = db.Collection(cfg.DBProductCollection)
 	usersColl = db.Collection(cfg.DBUsersCollection)
+	purchasesColl = db.Collection(cfg.DBPurchasesCollection)
+	countersColl = db.Collection(cfg.DBPurchaseCountersCollection)
+	wishlistsColl = db.Collection(cfg.DBWishlistsCollection)
+	notifyColl = db.Collection(cfg.DBNotificationsCollection)
+	pricesColl = db.Collection(cfg.DBPricesCollection)
+	webhooksColl = db.Collection(cfg.DBWebhooksCollection)
+	deliveriesColl = db.Collection(cfg.DBDeliveriesCollection)
+	outboxColl = db.Collection(cfg.DBOutboxCollection)
+	idempotencyColl = db.Collection(cfg.DBIdempotencyCollection)
 
 	isUserIndexUnique := true
 	indexModel := mongo.IndexModel{
-		Keys: bson.D{{"username", 1}},
+		Keys: bson.D{{Key: "username", Value: 1}},
 		Options: &options.IndexOptions{
 			Unique: &isUserIndexUnique,
 		},
@@ -58,6 +92,80 @@ func init() {
 	if err != nil {
 		log.Fatalf("Unable to create an index: %v", err)
 	}
+
+	_, err = purchasesColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "product_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index: %v", err)
+	}
+
+	_, err = wishlistsColl.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "product_ids", Value: 1}}},
+		{Keys: bson.D{{Key: "share_token", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index: %v", err)
+	}
+
+	// purchase counters only serialize checkouts, they go once their window has passed
+	_, err = countersColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index: %v", err)
+	}
+
+	_, err = productsColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "updated_at", Value: 1}},
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index: %v", err)
+	}
+
+	_, err = productsColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "publish_at", Value: 1}},
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index: %v", err)
+	}
+
+	_, err = pricesColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "changed_at", Value: 1}},
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index: %v", err)
+	}
+
+	_, err = deliveriesColl.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		// an event is delivered once per webhook however often the relay dispatches it, replays have no event_id
+		{
+			Keys:    bson.D{{Key: "event_id", Value: 1}, {Key: "webhook_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"event_id": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index: %v", err)
+	}
+
+	_, err = outboxColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "published_at", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index: %v", err)
+	}
+
+	_, err = idempotencyColl.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index: %v", err)
+	}
 }
 
 func addCorrelationId(next echo.HandlerFunc) echo.HandlerFunc {
@@ -98,9 +206,54 @@ func adminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// deprecationMiddleware announces the deprecation (RFC 9745) and sunset (RFC 8594) of the routes it wraps
+// and points to the same route under /v2
+func deprecationMiddleware(deprecatedAt, sunsetAt time.Time) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			path := strings.TrimPrefix(c.Request().URL.Path, "/v1")
+			header := c.Response().Header()
+			header.Set("Deprecation", fmt.Sprintf("@%d", deprecatedAt.Unix()))
+			header.Set("Sunset", sunsetAt.UTC().Format(http.TimeFormat))
+			header.Add("Link", fmt.Sprintf(`</v2%s>; rel="successor-version"`, path))
+			return next(c)
+		}
+	}
+}
+
+// apiVersions registers routes under every API version, v1 is served at the root too for existing clients
+type apiVersions struct {
+	v1         []*echo.Group
+	v2         *echo.Group
+	deprecated echo.MiddlewareFunc
+	// validate runs last, after the body limit and the JWT check of the route
+	validate echo.MiddlewareFunc
+}
+
+// add registers a route that is the same in every version
+func (api apiVersions) add(method, path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) {
+	api.addV1(method, path, h, m...)
+	api.addV2(method, path, h, m...)
+}
+
+// addV1 registers a route of v1 only, v2 registers its own handler for it
+func (api apiVersions) addV1(method, path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) {
+	m = append(append([]echo.MiddlewareFunc{api.deprecated}, m...), api.validate)
+	for _, g := range api.v1 {
+		g.Add(method, path, h, m...)
+	}
+}
+
+// addV2 registers a route of v2 only
+func (api apiVersions) addV2(method, path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) {
+	api.v2.Add(method, path, h, append(append([]echo.MiddlewareFunc{}, m...), api.validate)...)
+}
+
 func main() {
 	e := echo.New()
 	e.Logger.SetLevel(log.ERROR)
+	// clients asking for JSON:API get error objects
+	e.HTTPErrorHandler = hypermedia.ErrorHandler(problem.ErrorHandler)
 
 	e.Pre(middleware.RemoveTrailingSlash())
 	e.Pre(addCorrelationId)
@@ -114,16 +267,181 @@ func main() {
 		AuthScheme:  "Bearer",
 	})
 
-	h := &products.ProductsHandler{Coll: productsColl}
-	e.POST("/products", h.CreateProducts, middleware.BodyLimit("1M"), jwtConfig)
-	e.GET("/products", h.GetProducts, jwtConfig)
-	e.GET("/products/:id", h.GetProduct, jwtConfig)
-	e.PUT("/products/:id", h.UpdateProduct, middleware.BodyLimit("1M"), jwtConfig)
-	e.DELETE("/products/:id", h.DeleteProduct, jwtConfig, adminMiddleware)
+	// the specification is generated once every route is registered, the validator loads it on the first request
+	validator := &openapi.Validator{Responses: cfg.DevMode}
+	api := apiVersions{
+		v1:         []*echo.Group{e.Group(""), e.Group("/v1")},
+		v2:         e.Group("/v2"),
+		deprecated: deprecationMiddleware(cfg.V1DeprecatedAt, cfg.V1SunsetAt),
+		validate:   validator.Middleware,
+	}
+
+	// events are only recorded atomically with their writes, a standalone server runs without the outbox
+	var box *outbox.Store
+	if outbox.SupportsTransactions(context.Background(), db.Client()) {
+		box = &outbox.Store{Coll: outboxColl, Client: db.Client()}
+	} else {
+		log.Warnf("Transactions unavailable, running without the outbox: no domain events or webhooks, purchase limits are checked without a transaction")
+	}
+
+	// retried creations replay their first response, the guard goes after jwtConfig as keys are per user
+	idempotent := (&idempotency.Guard{
+		Records: &idempotency.Store{Coll: idempotencyColl},
+		TTL:     cfg.IdempotencyTTL,
+		Lock:    cfg.IdempotencyLock,
+	}).Middleware
+
+	wh := &wishlists.WishlistsHandler{Coll: wishlistsColl, NotificationsColl: notifyColl}
+	api.add(http.MethodPost, "/wishlists", wh.CreateWishlist, middleware.BodyLimit("1M"), jwtConfig)
+	api.add(http.MethodGet, "/wishlists", wh.GetWishlists, jwtConfig)
+	api.add(http.MethodGet, "/wishlists/shared/:token", wh.GetSharedWishlist)
+	api.add(http.MethodGet, "/wishlists/:id", wh.GetWishlist, jwtConfig)
+	api.add(http.MethodPut, "/wishlists/:id", wh.UpdateWishlist, middleware.BodyLimit("1M"), jwtConfig)
+	api.add(http.MethodDelete, "/wishlists/:id", wh.DeleteWishlist, jwtConfig)
+	api.add(http.MethodGet, "/notifications", wh.GetNotifications, jwtConfig)
+
+	h := &products.ProductsHandler{
+		Coll:         productsColl,
+		PricesColl:   pricesColl,
+		PriceWatcher: wh,
+		PriceBuckets: cfg.FacetPriceBuckets,
+
+		CompareMax:      cfg.CompareMax,
+		CompareCurrency: cfg.CompareCurrency,
+		ExchangeRates:   cfg.ExchangeRates,
+
+		Related: &products.RelatedIndex{},
+		RelatedWeights: products.RelatedWeights{
+			Vendor:     cfg.RelatedWeightVendor,
+			Accessory:  cfg.RelatedWeightAccessory,
+			PriceBand:  cfg.RelatedWeightPriceBand,
+			CoPurchase: cfg.RelatedWeightCoPurchase,
+			BandRatio:  cfg.RelatedPriceBandRatio,
+		},
+		Events: &products.Broker{History: cfg.EventsHistory},
+		Outbox: box,
+	}
+	if err := h.Events.WatchChanges(context.Background(), db, cfg.DBProductCollection, cfg.DBOutboxCollection); err != nil {
+		log.Warnf("Change streams unavailable, publishing product events in-process: %v", err)
+	}
+	go products.RunRelatedIndex(context.Background(), cfg.RelatedIndexInterval, h.Related, productsColl, purchasesColl)
+	go products.RunScheduler(context.Background(), cfg.PublishInterval, h.Events, box, productsColl)
+
+	api.addV1(http.MethodPost, "/products", h.CreateProducts, hypermedia.Acceptable, middleware.BodyLimit("1M"), jwtConfig, idempotent)
+	api.addV2(http.MethodPost, "/products", h.CreateProductsV2, hypermedia.Acceptable, middleware.BodyLimit("1M"), jwtConfig, idempotent)
+	api.addV1(http.MethodGet, "/products", h.GetProducts, hypermedia.Acceptable, jwtConfig)
+	api.addV2(http.MethodGet, "/products", h.GetProductsV2, hypermedia.Acceptable, jwtConfig)
+	api.add(http.MethodGet, "/products/facets", h.GetFacets, hypermedia.Acceptable, jwtConfig)
+	api.add(http.MethodGet, "/products/compare", h.CompareProducts, hypermedia.Acceptable, jwtConfig)
+	api.add(http.MethodGet, "/products/events", h.StreamEvents, jwtConfig)
+	api.addV1(http.MethodGet, "/products/:id", h.GetProduct, hypermedia.Acceptable, jwtConfig)
+	api.addV2(http.MethodGet, "/products/:id", h.GetProductV2, hypermedia.Acceptable, jwtConfig)
+	api.add(http.MethodGet, "/products/:id/prices", h.GetPrices, hypermedia.Acceptable, jwtConfig)
+	api.add(http.MethodGet, "/products/:id/related", h.GetRelated, hypermedia.Acceptable, jwtConfig)
+	api.addV1(http.MethodPut, "/products/:id", h.UpdateProduct, hypermedia.Acceptable, middleware.BodyLimit("1M"), jwtConfig)
+	api.addV2(http.MethodPut, "/products/:id", h.UpdateProductV2, hypermedia.Acceptable, middleware.BodyLimit("1M"), jwtConfig)
+	api.addV1(http.MethodDelete, "/products/:id", h.DeleteProduct, hypermedia.Acceptable, jwtConfig)
+	api.addV2(http.MethodDelete, "/products/:id", h.DeleteProductV2, hypermedia.Acceptable, jwtConfig)
+
+	hub := &realtime.Hub{SendBuffer: cfg.WSSendBuffer}
+	go hub.Run(context.Background(), h.Events)
+	rth := &realtime.RealtimeHandler{Hub: hub, PingInterval: cfg.WSPingInterval, AllowedOrigins: cfg.WSAllowedOrigins}
+	e.GET("/ws", rth.Connect, jwtConfig)
+
+	ph := &purchases.PurchasesHandler{
+		Coll:         purchasesColl,
+		ProductsColl: productsColl,
+		CountersColl: countersColl,
+		Transactions: box,
+		DefaultLimit: cfg.PurchaseLimitDefault,
+		Window:       cfg.PurchaseLimitWindow,
+		Notifier:     hub,
+	}
+	api.add(http.MethodPost, "/purchases/cart", ph.CheckCart, middleware.BodyLimit("1M"), jwtConfig)
+	api.add(http.MethodPost, "/purchases", ph.CreatePurchases, middleware.BodyLimit("1M"), jwtConfig, idempotent)
+
+	rh := &reports.ReportsHandler{Coll: productsColl, Cache: &reports.Cache{TTL: cfg.ReportsCacheTTL, MaxEntries: cfg.ReportsCacheSize}}
+	api.add(http.MethodGet, "/reports/vendors", rh.GetVendorsReport, jwtConfig, adminMiddleware)
+	api.add(http.MethodGet, "/reports/discounts", rh.GetDiscountsReport, jwtConfig, adminMiddleware)
+	api.add(http.MethodGet, "/reports/without-accessories", rh.GetWithoutAccessoriesReport, jwtConfig, adminMiddleware)
+	api.add(http.MethodGet, "/reports/newest", rh.GetNewestReport, jwtConfig, adminMiddleware)
+
+	dispatcher := &webhooks.Dispatcher{
+		Coll:           webhooksColl,
+		DeliveriesColl: deliveriesColl,
+		Client:         &http.Client{Timeout: cfg.WebhookTimeout},
+		MaxAttempts:    cfg.WebhookMaxAttempts,
+		Backoff:        cfg.WebhookBackoff,
+		Lock:           cfg.WebhookLock,
+	}
+	go dispatcher.Run(context.Background(), cfg.WebhookInterval)
+
+	// webhooks are fed by the outbox, the relay retries an event until every sink took it
+	bus := &outbox.Bus{}
+	bus.Subscribe(func(ctx context.Context, event outbox.Event) error {
+		return dispatcher.Dispatch(ctx, event.ID.Hex(), event.Type, event)
+	})
+	sinks := []outbox.Sink{bus}
+	if cfg.OutboxFile != "" {
+		sinks = append(sinks, &outbox.FileSink{Path: cfg.OutboxFile})
+	}
+	if cfg.OutboxWebhookURL != "" {
+		sinks = append(sinks, &outbox.WebhookSink{URL: cfg.OutboxWebhookURL, Client: &http.Client{Timeout: cfg.WebhookTimeout}})
+	}
+	relay := &outbox.Relay{
+		Coll:        outboxColl,
+		Sinks:       sinks,
+		Interval:    cfg.OutboxInterval,
+		Batch:       cfg.OutboxBatch,
+		MaxAttempts: cfg.OutboxMaxAttempts,
+		Lock:        cfg.OutboxLock,
+	}
+	go relay.Run(context.Background())
+
+	whh := &webhooks.WebhooksHandler{Coll: webhooksColl, DeliveriesColl: deliveriesColl, Dispatcher: dispatcher}
+	api.add(http.MethodPost, "/webhooks", whh.CreateWebhook, middleware.BodyLimit("1M"), jwtConfig, adminMiddleware)
+	api.add(http.MethodGet, "/webhooks", whh.GetWebhooks, jwtConfig, adminMiddleware)
+	api.add(http.MethodDelete, "/webhooks/:id", whh.DeleteWebhook, jwtConfig, adminMiddleware)
+	api.add(http.MethodGet, "/webhooks/:id/deliveries", whh.GetDeliveries, jwtConfig, adminMiddleware)
+	api.add(http.MethodPost, "/webhooks/deliveries/:id/replay", whh.ReplayDelivery, jwtConfig, adminMiddleware)
+
+	uh := &users.UsersHandler{Coll: usersColl, Outbox: box}
+	api.addV1(http.MethodPost, "/auth/register", uh.RegisterUser, hypermedia.Acceptable, middleware.BodyLimit("1M"), idempotent)
+	api.addV2(http.MethodPost, "/auth/register", uh.RegisterUserV2, hypermedia.Acceptable, middleware.BodyLimit("1M"), idempotent)
+	api.addV1(http.MethodPost, "/auth/login", uh.LoginUser, hypermedia.Acceptable)
+	api.addV2(http.MethodPost, "/auth/login", uh.LoginUserV2, hypermedia.Acceptable)
+
+	gh := &graph.GraphHandler{Products: h, Users: uh}
+	e.POST("/graphql", gh.Query, middleware.BodyLimit("1M"), jwtConfig)
+	e.GET("/graphql", gh.Query, jwtConfig)
+
+	oh := &openapi.OpenAPIHandler{}
+	e.GET("/openapi.json", oh.GetSpec)
+	e.GET("/docs", oh.GetDocs)
+	e.GET("/docs/*", oh.Assets())
+	// every route is registered by now
+	oh.Spec = openapi.Generate(e.Routes(), "learning-golang-restful-api", "1.0.0")
+	validator.Spec = oh.Spec
+
+	// the gRPC catalog runs next to Echo and shares the products handler
+	lis, err := net.Listen("tcp", fmt.Sprintf("%s:%s", cfg.AppHost, cfg.GRPCPort))
+	if err != nil {
+		log.Fatalf("Unable to listen for gRPC: %v", err)
+	}
+	gs := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.UnaryInterceptor([]byte(cfg.JwtSecret))),
+		grpc.StreamInterceptor(auth.StreamInterceptor([]byte(cfg.JwtSecret))),
+	)
+	productspb.RegisterProductServiceServer(gs, &products.ProductServer{Handler: h})
+	go func() {
+		if err := gs.Serve(lis); err != nil {
+			log.Fatalf("Unable to serve gRPC: %v", err)
+		}
+	}()
 
-	uh := &users.UsersHandler{Coll: usersColl}
-	e.POST("/auth/register", uh.RegisterUser)
-	e.POST("/auth/login", uh.LoginUser)
 	e.Logger.Infof("Listening on %s:%s ", cfg.AppHost, cfg.AppPort)
-	e.Logger.Fatal(e.Start(fmt.Sprintf("%s:%s", cfg.AppHost, cfg.AppPort)))
+	err = e.Start(fmt.Sprintf("%s:%s", cfg.AppHost, cfg.AppPort))
+	// Echo is done, let the gRPC calls in flight finish before exiting
+	gs.GracefulStop()
+	e.Logger.Fatal(err)
 }
@@ -0,0 +1,94 @@
+package negotiation
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	MIMEJSON       = "application/json"
+	MIMEXML        = "application/xml"
+	MIMETextXML    = "text/xml"
+	MIMEMsgPack    = "application/msgpack"
+	MIMEXMsgPack   = "application/x-msgpack"
+	MIMEVndMsgPack = "application/vnd.msgpack"
+	MIMECSV        = "text/csv"
+)
+
+// mediaRange is one entry of an Accept header
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mime := strings.ToLower(strings.TrimSpace(params[0]))
+		if mime == "" {
+			continue
+		}
+
+		r := mediaRange{q: 1}
+		r.typ, r.subtype = mime, "*"
+		if i := strings.Index(mime, "/"); i >= 0 {
+			r.typ, r.subtype = mime[:i], mime[i+1:]
+		}
+		for _, param := range params[1:] {
+			if value := strings.TrimSpace(param); strings.HasPrefix(value, "q=") {
+				r.q, _ = strconv.ParseFloat(strings.TrimPrefix(value, "q="), 64)
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// match finds the most specific range covering mime and returns its quality and position
+func match(ranges []mediaRange, mime string) (q float64, index int, ok bool) {
+	typ, subtype := mime, ""
+	if i := strings.Index(mime, "/"); i >= 0 {
+		typ, subtype = mime[:i], mime[i+1:]
+	}
+
+	specificity := 0
+	for i, r := range ranges {
+		s := 0
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			s = 3
+		case r.typ == typ && r.subtype == "*":
+			s = 2
+		case r.typ == "*" && r.subtype == "*":
+			s = 1
+		default:
+			continue
+		}
+		if s > specificity {
+			specificity, q, index, ok = s, r.q, i, true
+		}
+	}
+	return q, index, ok
+}
+
+// Negotiate picks the offer the Accept header prefers, by quality, then by the order of the header,
+// then by the order of offers. No Accept header means the first offer, nothing acceptable means false
+func Negotiate(accept string, offers []string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+
+	ranges := parseAccept(accept)
+	best, bestQ, bestIndex := "", 0.0, 0
+	for _, offer := range offers {
+		q, index, ok := match(ranges, offer)
+		if !ok || q <= 0 {
+			continue
+		}
+		if best == "" || q > bestQ || (q == bestQ && index < bestIndex) {
+			best, bestQ, bestIndex = offer, q, index
+		}
+	}
+	return best, best != ""
+}
@@ -0,0 +1,207 @@
+package negotiation
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Offers are the media types every handler can answer with, JSON first as the default
+var Offers = []string{MIMEJSON, MIMEXML, MIMETextXML, MIMEMsgPack, MIMEXMsgPack, MIMEVndMsgPack, MIMECSV}
+
+// Encode writes v in the given media type, MessagePack and CSV carry the JSON form of v
+// so ids and times look the same in every format
+func Encode(mimeType string, v interface{}) ([]byte, error) {
+	switch mimeType {
+	case MIMEJSON:
+		return json.Marshal(v)
+	case MIMEXML, MIMETextXML:
+		return encodeXML(v)
+	case MIMEMsgPack, MIMEXMsgPack, MIMEVndMsgPack:
+		form, err := jsonForm(v)
+		if err != nil {
+			return nil, err
+		}
+		return msgpack.Marshal(form)
+	case MIMECSV:
+		var buf bytes.Buffer
+		err := writeCSV(&buf, v)
+		return buf.Bytes(), err
+	}
+	return nil, fmt.Errorf("unsupported media type %s", mimeType)
+}
+
+// Write negotiates the response format from Accept and writes v in it, 406 when none is acceptable
+func Write(c echo.Context, status int, v interface{}) error {
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+	mimeType, ok := Negotiate(c.Request().Header.Get(echo.HeaderAccept), Offers)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotAcceptable, "Unable to answer in any of the accepted media types")
+	}
+	return Blob(c, status, mimeType, v)
+}
+
+// Blob writes v in the given media type
+func Blob(c echo.Context, status int, mimeType string, v interface{}) error {
+	if mimeType == MIMEJSON {
+		return c.JSON(status, v)
+	}
+
+	b, err := Encode(mimeType, v)
+	if err != nil {
+		log.Errorf("Unable to encode the response as %s: %v", mimeType, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to encode the response")
+	}
+	if strings.HasPrefix(mimeType, "text/") {
+		mimeType += "; charset=UTF-8"
+	}
+	return c.Blob(status, mimeType, b)
+}
+
+// contentType is the media type of the request body without its parameters
+func contentType(c echo.Context) string {
+	mimeType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	return mimeType
+}
+
+// Bind decodes the request body by its Content-Type, echo.ErrUnsupportedMediaType when it is not one
+// of JSON, XML or MessagePack, forms and empty bodies still go through the echo binder
+func Bind(c echo.Context, v interface{}) error {
+	req := c.Request()
+	if req.ContentLength == 0 {
+		// 400 "Request body can't be empty" like c.Bind
+		return c.Bind(v)
+	}
+
+	switch mimeType := contentType(c); mimeType {
+	case MIMEJSON:
+		return c.Bind(v)
+	case MIMEXML, MIMETextXML:
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		return decodeXML(body, v)
+	case MIMEMsgPack, MIMEXMsgPack, MIMEVndMsgPack:
+		body, err := msgpackToJSON(req.Body)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(body, v)
+	case echo.MIMEApplicationForm, echo.MIMEMultipartForm:
+		return c.Bind(v)
+	default:
+		return echo.ErrUnsupportedMediaType
+	}
+}
+
+// JSONBody turns a partial request body into JSON for the handlers that merge it into a stored document,
+// fields names the DTO the body describes so only the XML elements present in the body are kept
+func JSONBody(c echo.Context, fields interface{}) (io.ReadCloser, error) {
+	req := c.Request()
+
+	var body []byte
+	var err error
+	switch contentType(c) {
+	case MIMEJSON, "":
+		return req.Body, nil
+	case MIMEXML, MIMETextXML:
+		if body, err = ioutil.ReadAll(req.Body); err == nil {
+			body, err = xmlToJSON(body, fields)
+		}
+	case MIMEMsgPack, MIMEXMsgPack, MIMEVndMsgPack:
+		body, err = msgpackToJSON(req.Body)
+	default:
+		return nil, echo.ErrUnsupportedMediaType
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(body)), nil
+}
+
+// jsonForm is v as decoded from its JSON encoding, integers stay integers
+func jsonForm(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var form interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&form); err != nil {
+		return nil, err
+	}
+	return numbers(form), nil
+}
+
+func numbers(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = numbers(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = numbers(item)
+		}
+	}
+	return v
+}
+
+func msgpackToJSON(r io.Reader) ([]byte, error) {
+	var body interface{}
+	if err := msgpack.NewDecoder(r).Decode(&body); err != nil {
+		return nil, err
+	}
+	return json.Marshal(body)
+}
+
+// xmlToJSON decodes an XML body into a value of the type of fields and keeps the JSON fields
+// whose element was in the body, XML names match JSON names across the DTOs
+func xmlToJSON(body []byte, fields interface{}) ([]byte, error) {
+	value, err := newOf(fields)
+	if err != nil {
+		return nil, err
+	}
+	if err := xml.Unmarshal(body, value); err != nil {
+		return nil, err
+	}
+
+	present, err := childElements(body)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+	for name := range all {
+		if !present[name] {
+			delete(all, name)
+		}
+	}
+	return json.Marshal(all)
+}
@@ -0,0 +1,112 @@
+package negotiation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// writeCSV writes a struct or a slice of structs, one column per json tagged field,
+// anything else goes in a single value column
+func writeCSV(w io.Writer, v interface{}) error {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	if !value.IsValid() || value.Kind() == reflect.Ptr {
+		value = reflect.ValueOf("")
+	}
+
+	rows := value
+	if value.Kind() != reflect.Slice {
+		rows = reflect.Append(reflect.MakeSlice(reflect.SliceOf(value.Type()), 0, 1), value)
+	}
+
+	cw := csv.NewWriter(w)
+	columns := csvColumns(rows.Type().Elem())
+	if columns == nil {
+		if err := cw.Write([]string{"value"}); err != nil {
+			return err
+		}
+	} else {
+		var header []string
+		for _, column := range columns {
+			header = append(header, column.name)
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i)
+		for row.Kind() == reflect.Ptr || row.Kind() == reflect.Interface {
+			row = row.Elem()
+		}
+
+		var record []string
+		if columns == nil {
+			record = []string{csvField(row)}
+		} else {
+			for _, column := range columns {
+				record = append(record, csvField(row.Field(column.index)))
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+type csvColumn struct {
+	name  string
+	index int
+}
+
+func csvColumns(t reflect.Type) []csvColumn {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var columns []csvColumn
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		columns = append(columns, csvColumn{name: name, index: i})
+	}
+	return columns
+}
+
+// csvField writes a field as it looks in JSON, strings unquoted and string lists joined with |
+func csvField(field reflect.Value) string {
+	if !field.IsValid() {
+		return ""
+	}
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String {
+		return strings.Join(field.Interface().([]string), "|")
+	}
+
+	b, err := json.Marshal(field.Interface())
+	if err != nil || string(b) == "null" {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		return s
+	}
+	return string(b)
+}
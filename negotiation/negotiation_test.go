@@ -0,0 +1,149 @@
+package negotiation
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type item struct {
+	Name        string     `json:"name" xml:"name"`
+	Price       int        `json:"price" xml:"price"`
+	Accessories []string   `json:"accessories,omitempty" xml:"accessories>accessory,omitempty"`
+	PublishAt   *time.Time `json:"publish_at,omitempty" xml:"publish_at,omitempty"`
+}
+
+func (i item) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type plain item
+	start.Name.Local = "item"
+	return e.EncodeElement(plain(i), start)
+}
+
+func request(contentType, body string) echo.Context {
+	req := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, contentType)
+	return echo.New().NewContext(req, httptest.NewRecorder())
+}
+
+func TestNegotiate(t *testing.T) {
+	t.Run("Test offers by quality, header order and offer order", func(t *testing.T) {
+		for accept, want := range map[string]string{
+			"":                                  MIMEJSON,
+			"*/*":                               MIMEJSON,
+			"application/xml":                   MIMEXML,
+			"text/*":                            MIMETextXML,
+			"application/json;q=0.5, text/csv":  MIMECSV,
+			"application/x-msgpack, text/xml":   MIMEXMsgPack,
+			"application/*;q=0.2, text/csv;q=0": MIMEJSON,
+			"text/csv;q=0, */*;q=0.1":           MIMEJSON,
+		} {
+			mimeType, ok := Negotiate(accept, Offers)
+			assert.True(t, ok, accept)
+			assert.Equal(t, want, mimeType, accept)
+		}
+	})
+
+	t.Run("Test nothing acceptable", func(t *testing.T) {
+		_, ok := Negotiate("image/png, application/json;q=0", Offers)
+		assert.False(t, ok)
+	})
+
+	t.Run("Test Write answers 406", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products", nil)
+		req.Header.Set(echo.HeaderAccept, "image/png")
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+
+		err := Write(c, http.StatusOK, item{Name: "alexa"})
+		require.NotNil(t, err)
+		assert.Equal(t, http.StatusNotAcceptable, err.(*echo.HTTPError).Code)
+	})
+}
+
+func TestEncode(t *testing.T) {
+	publish := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	items := []item{{Name: "alexa", Price: 100, Accessories: []string{"charger", "remote"}, PublishAt: &publish}, {Name: "echo", Price: 50}}
+
+	t.Run("Test XML lists are named after their items", func(t *testing.T) {
+		b, err := Encode(MIMEXML, items)
+		require.Nil(t, err)
+		assert.Contains(t, string(b), "<items><item><name>alexa</name><price>100</price><accessories><accessory>charger</accessory><accessory>remote</accessory></accessories><publish_at>2026-01-02T03:04:05Z</publish_at></item><item>")
+	})
+
+	t.Run("Test XML of values without a mapping follows their JSON", func(t *testing.T) {
+		b, err := Encode(MIMEXML, map[string]interface{}{"deleted": 2, "by vendor": []string{"Amazon"}})
+		require.Nil(t, err)
+		assert.Contains(t, string(b), `<value><entry key="by vendor"><item>Amazon</item></entry><deleted>2</deleted></value>`)
+	})
+
+	t.Run("Test MessagePack carries the JSON form", func(t *testing.T) {
+		b, err := Encode(MIMEMsgPack, items[0])
+		require.Nil(t, err)
+
+		var decoded map[string]interface{}
+		require.Nil(t, msgpack.Unmarshal(b, &decoded))
+		assert.Equal(t, "alexa", decoded["name"])
+		assert.EqualValues(t, 100, decoded["price"])
+		assert.Equal(t, "2026-01-02T03:04:05Z", decoded["publish_at"])
+	})
+
+	t.Run("Test CSV has a column per field", func(t *testing.T) {
+		b, err := Encode(MIMECSV, items)
+		require.Nil(t, err)
+		assert.Equal(t, "name,price,accessories,publish_at\nalexa,100,charger|remote,2026-01-02T03:04:05Z\necho,50,,\n", string(b))
+
+		b, err = Encode(MIMECSV, int64(3))
+		require.Nil(t, err)
+		assert.Equal(t, "value\n3\n", string(b))
+	})
+}
+
+func TestBind(t *testing.T) {
+	t.Run("Test XML list", func(t *testing.T) {
+		var items []item
+		c := request(MIMEXML, `<items><item><name>alexa</name><price>100</price><accessories><accessory>charger</accessory></accessories></item><item><name>echo</name></item></items>`)
+		require.Nil(t, Bind(c, &items))
+		assert.Equal(t, []item{{Name: "alexa", Price: 100, Accessories: []string{"charger"}}, {Name: "echo"}}, items)
+	})
+
+	t.Run("Test MessagePack", func(t *testing.T) {
+		body, _ := msgpack.Marshal(map[string]interface{}{"name": "alexa", "price": 100})
+		var i item
+		require.Nil(t, Bind(request(MIMEMsgPack, string(body)), &i))
+		assert.Equal(t, item{Name: "alexa", Price: 100}, i)
+	})
+
+	t.Run("Test unsupported media type", func(t *testing.T) {
+		var i item
+		assert.Equal(t, echo.ErrUnsupportedMediaType, Bind(request(MIMECSV, "name\nalexa\n"), &i))
+	})
+
+	t.Run("Test empty body", func(t *testing.T) {
+		var i item
+		err := Bind(request(MIMEXML, ""), &i)
+		assert.Equal(t, echo.NewHTTPError(http.StatusBadRequest, "Request body can't be empty"), err)
+	})
+
+	t.Run("Test partial XML keeps only the elements sent", func(t *testing.T) {
+		body, err := JSONBody(request(MIMETextXML, `<item><price>0</price><accessories></accessories></item>`), item{})
+		require.Nil(t, err)
+		b, _ := ioutil.ReadAll(body)
+		assert.JSONEq(t, `{"price": 0}`, string(b))
+	})
+
+	t.Run("Test partial JSON passes through", func(t *testing.T) {
+		body, err := JSONBody(request(MIMEJSON, `{"name":"alexa"}`), item{})
+		require.Nil(t, err)
+		b, _ := ioutil.ReadAll(body)
+		assert.True(t, bytes.Equal([]byte(`{"name":"alexa"}`), b))
+	})
+}
@@ -0,0 +1,194 @@
+package negotiation
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+)
+
+// elementNames are the JSON keys usable as element names as they are, others go in <entry key="">
+var elementNames = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+var marshalerType = reflect.TypeOf((*xml.Marshaler)(nil)).Elem()
+
+// encodeXML marshals the DTOs that know their XML form, a slice of them becomes a root named after
+// its items e.g. <products><product>, anything else is written from its JSON form
+func encodeXML(v interface{}) ([]byte, error) {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+
+	switch {
+	case value.IsValid() && value.Type().Implements(marshalerType):
+		if err := enc.Encode(value.Interface()); err != nil {
+			return nil, err
+		}
+	case value.Kind() == reflect.Slice && value.Type().Elem().Implements(marshalerType):
+		name, err := elementName(value.Type().Elem())
+		if err != nil {
+			return nil, err
+		}
+		root := xml.StartElement{Name: xml.Name{Local: name + "s"}}
+		if err := enc.EncodeToken(root); err != nil {
+			return nil, err
+		}
+		for i := 0; i < value.Len(); i++ {
+			if err := enc.Encode(value.Index(i).Interface()); err != nil {
+				return nil, err
+			}
+		}
+		if err := enc.EncodeToken(root.End()); err != nil {
+			return nil, err
+		}
+	default:
+		form, err := jsonForm(v)
+		if err != nil {
+			return nil, err
+		}
+		name := "value"
+		if _, ok := form.([]interface{}); ok {
+			name = "items"
+		}
+		if err := encodeForm(enc, name, form); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// elementName is the root element a type marshals to
+func elementName(t reflect.Type) (string, error) {
+	b, err := xml.Marshal(reflect.Zero(t).Interface())
+	if err != nil {
+		return "", err
+	}
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// encodeForm writes a decoded JSON value, object keys become elements and array entries <item>
+func encodeForm(enc *xml.Encoder, name string, form interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !elementNames.MatchString(name) {
+		start = xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}}}
+	}
+	switch value := form.(type) {
+	case map[string]interface{}:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := encodeForm(enc, key, value[key]); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	case []interface{}:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, item := range value {
+			if err := encodeForm(enc, "item", item); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	case nil:
+		return enc.EncodeElement("", start)
+	default:
+		return enc.EncodeElement(fmt.Sprint(value), start)
+	}
+}
+
+// decodeXML unmarshals body into v, a pointer to a slice takes every child of the root element
+func decodeXML(body []byte, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Slice {
+		return xml.Unmarshal(body, v)
+	}
+
+	list := value.Elem()
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				depth++
+				continue
+			}
+			item := reflect.New(list.Type().Elem())
+			if err := dec.DecodeElement(item.Interface(), &t); err != nil {
+				return err
+			}
+			list.Set(reflect.Append(list, item.Elem()))
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// childElements names the elements directly under the root
+func childElements(body []byte) (map[string]bool, error) {
+	names := map[string]bool{}
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 2 {
+				names[t.Name.Local] = true
+			}
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				return names, nil
+			}
+		}
+	}
+}
+
+func newOf(v interface{}) (interface{}, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, fmt.Errorf("no type to decode into")
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return reflect.New(t).Interface(), nil
+}
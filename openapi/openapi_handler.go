@@ -0,0 +1,34 @@
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/labstack/echo"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+//go:embed docs.html
+var docsPage string
+
+type OpenAPIHandler struct {
+	// Spec is generated once every route is registered
+	Spec *openapi3.T
+}
+
+func (h *OpenAPIHandler) GetSpec(c echo.Context) error {
+	if h.Spec == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "The specification is not generated yet")
+	}
+	return c.JSON(http.StatusOK, h.Spec)
+}
+
+func (h *OpenAPIHandler) GetDocs(c echo.Context) error {
+	return c.HTML(http.StatusOK, docsPage)
+}
+
+// Assets serves the bundled Swagger UI files under /docs/
+func (h *OpenAPIHandler) Assets() echo.HandlerFunc {
+	return echo.WrapHandler(http.StripPrefix("/docs/", http.FileServer(http.FS(swaggerFiles.FS))))
+}
@@ -0,0 +1,127 @@
+package openapi
+
+import (
+	"learning-golang-restful-api/idempotency"
+	"learning-golang-restful-api/products"
+	"learning-golang-restful-api/purchases"
+	"learning-golang-restful-api/reports"
+	"learning-golang-restful-api/users"
+	"learning-golang-restful-api/webhooks"
+	"learning-golang-restful-api/wishlists"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// operation is what the routes alone cannot tell about an endpoint,
+// Body and Response are zero values whose types describe the payloads
+type operation struct {
+	Summary string
+	Public  bool
+	Body    interface{}
+	// Partial bodies are merged into the stored document, none of their fields are required
+	Partial  bool
+	Status   int
+	Response interface{}
+	// Or is the other shape of Response, e.g. listings asked for their facets
+	Or interface{}
+	// Query are the parameters besides the path ones, headers included
+	Query openapi3.Parameters
+}
+
+func query(name string, schema *openapi3.Schema) *openapi3.ParameterRef {
+	return &openapi3.ParameterRef{Value: openapi3.NewQueryParameter(name).WithSchema(schema)}
+}
+
+var (
+	page = openapi3.Parameters{
+		query("limit", openapi3.NewIntegerSchema().WithMin(0)),
+		query("offset", openapi3.NewIntegerSchema().WithMin(0)),
+	}
+	// include expands relations in JSON:API and HAL responses
+	include       = query("include", openapi3.NewStringSchema())
+	productsQuery = append(openapi3.Parameters{
+		query("mine", openapi3.NewBoolSchema()),
+		query("updated_since", openapi3.NewStringSchema()),
+		query("facets", openapi3.NewBoolSchema()),
+		include,
+	}, page...)
+	// idempotencyKey makes retries of a creation replay its first response
+	idempotencyKey = openapi3.Parameters{&openapi3.ParameterRef{
+		Value: openapi3.NewHeaderParameter(idempotency.HeaderIdempotencyKey).WithSchema(openapi3.NewStringSchema().WithMaxLength(idempotency.MaxKeyLength)),
+	}}
+	reportFormat = openapi3.Parameters{
+		query("format", openapi3.NewStringSchema().WithEnum("json", "csv")),
+	}
+)
+
+// operations describes the registered routes, keyed by method and Echo path without the version,
+// a key with the version overrides it for the routes a version changed
+var operations = map[string]operation{
+	"POST /auth/register": {Summary: "Register a user, the token is returned in X-Auth-Token", Public: true, Body: users.User{}, Status: http.StatusCreated, Response: users.User{}, Query: idempotencyKey},
+	"POST /auth/login":    {Summary: "Log in, the token is returned in X-Auth-Token", Public: true, Body: users.User{}, Status: http.StatusCreated, Response: users.User{}},
+
+	"POST /v2/auth/register": {Summary: "Register a user, the token is returned in X-Auth-Token", Public: true, Body: users.User{}, Status: http.StatusCreated, Response: users.User{}, Query: idempotencyKey},
+	"POST /v2/auth/login":    {Summary: "Log in, the token is returned in the body and in X-Auth-Token", Public: true, Body: users.User{}, Response: users.Session{}},
+
+	"POST /v2/products":       {Summary: "Create products", Body: []products.ProductV2{}, Status: http.StatusCreated, Response: []products.ProductV2{}, Query: idempotencyKey},
+	"GET /v2/products":        {Summary: "List products, any other query param filters on the field of the same name", Response: []products.ProductV2{}, Or: products.FacetedList{}, Query: productsQuery},
+	"GET /v2/products/:id":    {Summary: "Get a product", Response: products.ProductV2{}, Query: openapi3.Parameters{include}},
+	"PUT /v2/products/:id":    {Summary: "Update a product, only its creator or an admin may", Body: products.ProductV2{}, Partial: true, Response: products.ProductV2{}},
+	"DELETE /v2/products/:id": {Summary: "Delete a product, only its creator or an admin may", Status: http.StatusNoContent},
+
+	"POST /products": {Summary: "Create products", Body: []products.Product{}, Status: http.StatusCreated, Response: []string{}, Query: idempotencyKey},
+	"GET /products": {
+		Summary:  "List products, any other query param filters on the field of the same name",
+		Response: []products.Product{},
+		Or:       products.FacetedList{},
+		Query:    productsQuery,
+	},
+	"GET /products/facets":  {Summary: "Facet counts for the filtered products", Response: products.Facets{}, Query: openapi3.Parameters{query("price_buckets", openapi3.NewStringSchema())}},
+	"GET /products/compare": {Summary: "Compare products side by side", Response: products.Comparison{}, Query: openapi3.Parameters{query("ids", openapi3.NewStringSchema())}},
+	"GET /products/events": {
+		Summary: "Server-Sent Events of product changes",
+		Query:   openapi3.Parameters{query("vendor", openapi3.NewStringSchema()), query("product_id", openapi3.NewStringSchema().WithPattern(ObjectIDPattern))},
+	},
+	"GET /products/:id": {Summary: "Get a product", Response: products.Product{}, Query: openapi3.Parameters{include}},
+	"GET /products/:id/prices": {
+		Summary:  "Price history of a product",
+		Response: []products.PriceBucket{},
+		Query: openapi3.Parameters{
+			query("from", openapi3.NewStringSchema()),
+			query("to", openapi3.NewStringSchema()),
+			query("interval", openapi3.NewStringSchema().WithEnum("hour", "day", "week", "month")),
+		},
+	},
+	"GET /products/:id/related": {Summary: "Related products with their scores", Response: []products.RelatedProduct{}, Query: openapi3.Parameters{query("limit", openapi3.NewIntegerSchema().WithMin(1))}},
+	"PUT /products/:id":         {Summary: "Update a product, only its creator or an admin may", Body: products.Product{}, Partial: true, Response: products.Product{}},
+	"DELETE /products/:id":      {Summary: "Delete a product, only its creator or an admin may", Response: 0},
+
+	"POST /purchases":      {Summary: "Buy products within their purchase limits", Body: []purchases.Purchase{}, Status: http.StatusCreated, Response: []string{}, Query: idempotencyKey},
+	"POST /purchases/cart": {Summary: "Check a cart against the purchase limits without buying it", Body: []purchases.Purchase{}, Response: []purchases.Allowance{}},
+
+	"POST /wishlists":              {Summary: "Create a wishlist", Body: wishlists.Wishlist{}, Status: http.StatusCreated, Response: ""},
+	"GET /wishlists":               {Summary: "List the caller's wishlists", Response: []wishlists.Wishlist{}},
+	"GET /wishlists/shared/:token": {Summary: "Read a shared wishlist", Public: true, Response: wishlists.Wishlist{}},
+	"GET /wishlists/:id":           {Summary: "Get a wishlist", Response: wishlists.Wishlist{}},
+	"PUT /wishlists/:id":           {Summary: "Update a wishlist", Body: wishlists.Wishlist{}, Partial: true, Response: wishlists.Wishlist{}},
+	"DELETE /wishlists/:id":        {Summary: "Delete a wishlist", Response: 0},
+	"GET /notifications":           {Summary: "Price drop notifications of the caller", Response: []wishlists.Notification{}},
+
+	"GET /reports/vendors":                 {Summary: "Product count and average price per vendor, admins only", Response: []reports.VendorRow{}, Query: reportFormat},
+	"GET /reports/discounts":               {Summary: "Discount distribution, admins only", Response: []reports.DiscountRow{}, Query: reportFormat},
+	"GET /reports/without-accessories":     {Summary: "Products without accessories, admins only", Response: []reports.BareProductRow{}, Query: reportFormat},
+	"GET /reports/newest":                  {Summary: "Newest products per week, admins only", Response: []reports.WeekRow{}, Query: reportFormat},
+	"POST /webhooks":                       {Summary: "Register a webhook, admins only", Body: webhooks.Webhook{}, Status: http.StatusCreated, Response: webhooks.Webhook{}},
+	"GET /webhooks":                        {Summary: "List webhooks, admins only", Response: []webhooks.Webhook{}},
+	"DELETE /webhooks/:id":                 {Summary: "Delete a webhook, admins only", Response: 0},
+	"GET /webhooks/:id/deliveries":         {Summary: "Delivery log of a webhook, admins only", Response: []webhooks.Delivery{}},
+	"POST /webhooks/deliveries/:id/replay": {Summary: "Send a logged delivery again, admins only", Status: http.StatusAccepted, Response: webhooks.Delivery{}},
+
+	"GET /ws":       {Summary: "WebSocket of catalog and inventory updates"},
+	"POST /graphql": {Summary: "GraphQL over products and users"},
+	"GET /graphql":  {Summary: "GraphQL over products and users", Query: openapi3.Parameters{query("query", openapi3.NewStringSchema())}},
+
+	"GET /openapi.json": {Summary: "This document", Public: true},
+	"GET /docs":         {Summary: "Swagger UI", Public: true},
+}
@@ -0,0 +1,181 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ObjectIDPattern matches the hex form of a Mongo ObjectID
+const ObjectIDPattern = "^[0-9a-fA-F]{24}$"
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+// schemaBuilder turns DTO structs into schemas, named structs become components referenced by $ref
+type schemaBuilder struct {
+	components openapi3.Schemas
+}
+
+// ref returns the schema of t, registering named structs as components
+func (b *schemaBuilder) ref(t reflect.Type) *openapi3.SchemaRef {
+	switch {
+	case t == timeType:
+		return openapi3.NewSchemaRef("", openapi3.NewDateTimeSchema())
+	case t == objectIDType:
+		return openapi3.NewSchemaRef("", openapi3.NewStringSchema().WithPattern(ObjectIDPattern))
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		ref := b.ref(t.Elem())
+		if ref.Ref != "" {
+			return ref
+		}
+		return openapi3.NewSchemaRef("", ref.Value.WithNullable())
+	case reflect.String:
+		return openapi3.NewSchemaRef("", openapi3.NewStringSchema())
+	case reflect.Bool:
+		return openapi3.NewSchemaRef("", openapi3.NewBoolSchema())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openapi3.NewSchemaRef("", openapi3.NewIntegerSchema())
+	case reflect.Float32, reflect.Float64:
+		return openapi3.NewSchemaRef("", openapi3.NewFloat64Schema())
+	case reflect.Array:
+		return openapi3.NewSchemaRef("", &openapi3.Schema{Type: "array", Items: b.ref(t.Elem())})
+	case reflect.Slice:
+		// nil slices and maps encode as null
+		return openapi3.NewSchemaRef("", &openapi3.Schema{Type: "array", Items: b.ref(t.Elem()), Nullable: true})
+	case reflect.Map:
+		schema := openapi3.NewObjectSchema().WithNullable()
+		schema.AdditionalProperties = b.ref(t.Elem())
+		return openapi3.NewSchemaRef("", schema)
+	case reflect.Struct:
+		return b.component(t)
+	}
+	return openapi3.NewSchemaRef("", &openapi3.Schema{})
+}
+
+// component registers a struct under its type name and returns a reference to it
+func (b *schemaBuilder) component(t reflect.Type) *openapi3.SchemaRef {
+	ref := "#/components/schemas/" + t.Name()
+	if schema, ok := b.components[t.Name()]; ok {
+		return openapi3.NewSchemaRef(ref, schema.Value)
+	}
+
+	schema := openapi3.NewObjectSchema()
+	// registered before the fields so self references terminate
+	b.components[t.Name()] = openapi3.NewSchemaRef("", schema)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		field := b.ref(f.Type)
+		if required := applyRules(field, f.Tag.Get("validate")); required {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = field
+	}
+
+	return openapi3.NewSchemaRef(ref, schema)
+}
+
+// applyRules turns validate tag rules into schema constraints and reports whether the field is required,
+// rules after dive apply to the items of a list
+func applyRules(ref *openapi3.SchemaRef, tag string) bool {
+	if tag == "" || ref.Ref != "" {
+		return strings.Contains(","+tag+",", ",required,")
+	}
+
+	rules := strings.Split(tag, ",")
+	required, omitempty := false, false
+	for i, rule := range rules {
+		if rule == "dive" {
+			if ref.Value.Items != nil && ref.Value.Items.Ref == "" {
+				// share nothing with the component cache
+				items := *ref.Value.Items.Value
+				ref.Value.Items = openapi3.NewSchemaRef("", &items)
+				applyRules(ref.Value.Items, strings.Join(rules[i+1:], ","))
+			}
+			break
+		}
+
+		name, param := rule, ""
+		if eq := strings.Index(rule, "="); eq >= 0 {
+			name, param = rule[:eq], rule[eq+1:]
+		}
+		switch name {
+		case "required":
+			required = true
+			continue
+		case "omitempty":
+			omitempty = true
+			continue
+		}
+		applyRule(ref.Value, name, param)
+		// the validator skips empty values, the enum has to accept them too
+		if name == "oneof" && omitempty {
+			ref.Value.Enum = append(ref.Value.Enum, "")
+		}
+	}
+	return required
+}
+
+// applyRule maps one validator rule to the matching constraint of the schema type
+func applyRule(schema *openapi3.Schema, name string, param string) {
+	n, _ := strconv.ParseFloat(param, 64)
+	switch name {
+	case "email":
+		schema.Format = "email"
+	case "url":
+		schema.Format = "uri"
+	case "oneof":
+		for _, value := range strings.Fields(param) {
+			schema.Enum = append(schema.Enum, value)
+		}
+	case "len":
+		applyRule(schema, "min", param)
+		applyRule(schema, "max", param)
+	case "min", "gte":
+		switch schema.Type {
+		case "string":
+			schema.MinLength = uint64(n)
+		case "array":
+			schema.MinItems = uint64(n)
+		default:
+			schema.Min = &n
+		}
+	case "max", "lte":
+		u := uint64(n)
+		switch schema.Type {
+		case "string":
+			schema.MaxLength = &u
+		case "array":
+			schema.MaxItems = &u
+		default:
+			schema.Max = &n
+		}
+	case "gt":
+		schema.Min, schema.ExclusiveMin = &n, true
+	case "lt":
+		schema.Max, schema.ExclusiveMax = &n, true
+	}
+}
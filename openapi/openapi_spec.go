@@ -0,0 +1,150 @@
+package openapi
+
+import (
+	"learning-golang-restful-api/problem"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/labstack/echo"
+)
+
+var (
+	pathParam     = regexp.MustCompile(`:(\w+)`)
+	versionPrefix = regexp.MustCompile(`^/(v\d+)(/.*)$`)
+)
+
+// handlerName trims "pkg.(*Handler).Method-fm" down to "Method"
+func handlerName(name string) string {
+	name = strings.TrimSuffix(name, "-fm")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// splitVersion splits "/v2/products" into "v2" and "/products", unversioned paths have no version
+func splitVersion(path string) (string, string) {
+	if match := versionPrefix.FindStringSubmatch(path); match != nil {
+		return match[1], match[2]
+	}
+	return "", path
+}
+
+// Generate describes the routes registered on Echo, wildcard routes such as static assets are left out
+func Generate(routes []*echo.Route, title string, version string) *openapi3.T {
+	b := &schemaBuilder{components: openapi3.Schemas{}}
+
+	// the body the central error handler writes
+	problemRef := b.ref(reflect.TypeOf(problem.Problem{}))
+
+	spec := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info:    &openapi3.Info{Title: title, Version: version},
+		Paths:   openapi3.Paths{},
+		Components: openapi3.Components{
+			Schemas: b.components,
+			SecuritySchemes: openapi3.SecuritySchemes{
+				"jwt": &openapi3.SecuritySchemeRef{Value: &openapi3.SecurityScheme{
+					Type:        "apiKey",
+					In:          "header",
+					Name:        "X-Auth-Token",
+					Description: "Bearer <token> as returned by /auth/login",
+				}},
+			},
+		},
+	}
+
+	// routes come out of a map, sort them so the document is stable
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	registered := map[string]bool{}
+	for _, route := range routes {
+		registered[route.Method+" "+route.Path] = true
+	}
+
+	ids := map[string]bool{}
+	for _, route := range routes {
+		if strings.Contains(route.Path, "*") {
+			continue
+		}
+
+		version, path := splitVersion(route.Path)
+		op, ok := operations[route.Method+" "+route.Path]
+		if !ok {
+			op = operations[route.Method+" "+path]
+		}
+		operation := openapi3.NewOperation()
+		operation.Summary = op.Summary
+		operation.Tags = []string{strings.Split(strings.TrimPrefix(path, "/"), "/")[0]}
+		// the root serves v1 for existing clients
+		operation.Deprecated = version == "v1" || (version == "" && registered[route.Method+" /v1"+path])
+
+		operation.OperationID = version + strings.TrimSuffix(handlerName(route.Name), strings.ToUpper(version))
+		if ids[operation.OperationID] {
+			operation.OperationID += strings.Title(strings.ToLower(route.Method))
+		}
+		ids[operation.OperationID] = true
+
+		if !op.Public {
+			operation.Security = openapi3.NewSecurityRequirements().With(openapi3.NewSecurityRequirement().Authenticate("jwt"))
+		}
+
+		for _, match := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+			schema := openapi3.NewStringSchema()
+			if match[1] == "id" {
+				schema.Pattern = ObjectIDPattern
+			}
+			operation.AddParameter(openapi3.NewPathParameter(match[1]).WithSchema(schema))
+		}
+		operation.Parameters = append(operation.Parameters, op.Query...)
+
+		if op.Body != nil {
+			body := b.ref(reflect.TypeOf(op.Body))
+			if op.Partial {
+				partial := *body.Value
+				partial.Required = nil
+				body = openapi3.NewSchemaRef("", &partial)
+			}
+			operation.RequestBody = &openapi3.RequestBodyRef{
+				Value: openapi3.NewRequestBody().WithRequired(true).WithJSONSchemaRef(body),
+			}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		response := openapi3.NewResponse().WithDescription(http.StatusText(status))
+		if op.Response != nil {
+			schema := b.ref(reflect.TypeOf(op.Response))
+			if op.Or != nil {
+				schema = openapi3.NewSchemaRef("", &openapi3.Schema{OneOf: openapi3.SchemaRefs{schema, b.ref(reflect.TypeOf(op.Or))}})
+			}
+			response = response.WithJSONSchemaRef(schema)
+		}
+		operation.Responses = openapi3.Responses{
+			strconv.Itoa(status): &openapi3.ResponseRef{Value: response},
+			"default": &openapi3.ResponseRef{Value: openapi3.NewResponse().WithDescription("Problem").WithContent(openapi3.Content{
+				problem.MIMEProblemJSON: openapi3.NewMediaType().WithSchemaRef(problemRef),
+			})},
+		}
+
+		templated := pathParam.ReplaceAllString(route.Path, "{$1}")
+		if spec.Paths[templated] == nil {
+			spec.Paths[templated] = &openapi3.PathItem{}
+		}
+		spec.Paths[templated].SetOperation(route.Method, operation)
+	}
+
+	return spec
+}
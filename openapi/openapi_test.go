@@ -0,0 +1,289 @@
+package openapi
+
+import (
+	"context"
+	"encoding/json"
+	"learning-golang-restful-api/products"
+	"learning-golang-restful-api/users"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newServer() (*echo.Echo, *OpenAPIHandler) {
+	e := echo.New()
+	ph := &products.ProductsHandler{}
+	uh := &users.UsersHandler{}
+	oh := &OpenAPIHandler{}
+
+	e.POST("/auth/register", uh.RegisterUser)
+	e.POST("/products", ph.CreateProducts)
+	e.GET("/products", ph.GetProducts)
+	e.GET("/products/:id", ph.GetProduct)
+	e.PUT("/products/:id", ph.UpdateProduct)
+	e.GET("/openapi.json", oh.GetSpec)
+	e.GET("/docs", oh.GetDocs)
+	e.GET("/docs/*", oh.Assets())
+	oh.Spec = Generate(e.Routes(), "test", "1.0.0")
+	return e, oh
+}
+
+func TestOpenAPI(t *testing.T) {
+	e, oh := newServer()
+	spec := oh.Spec
+
+	t.Run("Test spec is a valid document", func(t *testing.T) {
+		assert.Nil(t, spec.Validate(context.Background()))
+		assert.NotContains(t, spec.Paths, "/docs/*")
+	})
+
+	t.Run("Test routes become operations", func(t *testing.T) {
+		get := spec.Paths["/products/{id}"].Get
+		require.NotNil(t, get)
+		assert.Equal(t, "GetProduct", get.OperationID)
+		assert.Equal(t, ObjectIDPattern, get.Parameters.GetByInAndName("path", "id").Schema.Value.Pattern)
+		assert.NotNil(t, get.Security)
+
+		register := spec.Paths["/auth/register"].Post
+		assert.Nil(t, register.Security)
+		assert.Contains(t, register.Responses, "201")
+		assert.Equal(t, "#/components/schemas/User", register.RequestBody.Value.Content.Get("application/json").Schema.Ref)
+
+		assert.NotNil(t, spec.Paths["/products"].Get.Parameters.GetByInAndName("query", "limit"))
+	})
+
+	t.Run("Test validate tags become constraints", func(t *testing.T) {
+		product := spec.Components.Schemas["Product"].Value
+		assert.ElementsMatch(t, []string{"name", "price", "currency", "vendor", "accessories"}, product.Required)
+		assert.Equal(t, uint64(10), *product.Properties["name"].Value.MaxLength)
+		assert.Equal(t, float64(2000), *product.Properties["price"].Value.Max)
+		assert.Equal(t, uint64(3), product.Properties["currency"].Value.MinLength)
+		assert.Equal(t, uint64(3), *product.Properties["currency"].Value.MaxLength)
+		assert.Equal(t, []interface{}{"draft", "scheduled", "published", "archived", ""}, product.Properties["status"].Value.Enum)
+		assert.Equal(t, "#/components/schemas/DiscountRule", product.Properties["discount_rules"].Value.Items.Ref)
+		assert.Equal(t, "date-time", product.Properties["created_at"].Value.Format)
+
+		user := spec.Components.Schemas["User"].Value
+		assert.Equal(t, "email", user.Properties["username"].Value.Format)
+		assert.Equal(t, uint64(8), user.Properties["password"].Value.MinLength)
+		assert.ElementsMatch(t, []string{"username", "password"}, user.Required)
+	})
+
+	t.Run("Test spec and docs are served", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		e.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		loaded, err := openapi3.NewLoader().LoadFromData(res.Body.Bytes())
+		require.Nil(t, err)
+		assert.Nil(t, loaded.Validate(context.Background()))
+
+		res = httptest.NewRecorder()
+		e.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/docs", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), `url: "/openapi.json"`)
+
+		res = httptest.NewRecorder()
+		e.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/docs/swagger-ui-bundle.js", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+	})
+
+	t.Run("Test operation ids stay unique", func(t *testing.T) {
+		ids := map[string]bool{}
+		for _, item := range spec.Paths {
+			for _, op := range item.Operations() {
+				assert.False(t, ids[op.OperationID], op.OperationID)
+				ids[op.OperationID] = true
+			}
+		}
+		b, _ := json.Marshal(spec)
+		assert.NotContains(t, string(b), ":id")
+	})
+}
+
+func newValidated(responses bool, product products.Product) (*echo.Echo, *bool) {
+	e := echo.New()
+	called := new(bool)
+	get := func(c echo.Context) error {
+		*called = true
+		return c.JSON(http.StatusOK, product)
+	}
+	create := func(c echo.Context) error {
+		*called = true
+		return c.JSON(http.StatusCreated, []products.Product{product})
+	}
+	e.GET("/products", get)
+	e.GET("/products/:id", get)
+	e.PUT("/products/:id", get)
+	e.POST("/products", create)
+	v := &Validator{Spec: Generate(e.Routes(), "test", "1.0.0"), Responses: responses}
+	e.Use(v.Middleware)
+	// registered after the spec so it is not described by it
+	e.GET("/healthz", func(c echo.Context) error { return c.String(http.StatusOK, "ok") })
+	return e, called
+}
+
+func failures(t *testing.T, res *httptest.ResponseRecorder) []Violation {
+	var body struct {
+		Errors []Violation `json:"errors"`
+	}
+	require.Nil(t, json.Unmarshal(res.Body.Bytes(), &body))
+	return body.Errors
+}
+
+func TestValidator(t *testing.T) {
+	valid := products.Product{Name: "alexa", Price: 100, Currency: "USD", Vendor: "Amazon", Accessories: []string{"charger"}}
+
+	t.Run("Test invalid path id", func(t *testing.T) {
+		e, called := newValidated(false, valid)
+		res := httptest.NewRecorder()
+		e.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/products/not-an-id", nil))
+
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.False(t, *called)
+		errs := failures(t, res)
+		require.Len(t, errs, 1)
+		assert.Equal(t, "path", errs[0].In)
+		assert.Equal(t, "id", errs[0].Field)
+		assert.Equal(t, "pattern", errs[0].Rule)
+	})
+
+	t.Run("Test invalid query param", func(t *testing.T) {
+		e, _ := newValidated(false, valid)
+		res := httptest.NewRecorder()
+		e.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/products?limit=abc", nil))
+
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		errs := failures(t, res)
+		require.Len(t, errs, 1)
+		assert.Equal(t, "query", errs[0].In)
+		assert.Equal(t, "limit", errs[0].Field)
+	})
+
+	t.Run("Test invalid body", func(t *testing.T) {
+		e, called := newValidated(false, valid)
+		req := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(`[{"name":"much too long a name","price":100,"currency":"USD","vendor":"Amazon","accessories":[]}]`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		res := httptest.NewRecorder()
+		e.ServeHTTP(res, req)
+
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.False(t, *called)
+		errs := failures(t, res)
+		require.Len(t, errs, 1)
+		assert.Equal(t, "body", errs[0].In)
+		assert.Equal(t, "0/name", errs[0].Field)
+		assert.Equal(t, "maxLength", errs[0].Rule)
+	})
+
+	t.Run("Test partial update bodies are accepted", func(t *testing.T) {
+		e, called := newValidated(false, valid)
+		req := httptest.NewRequest(http.MethodPut, "/products/5f5e1f3f2c8b9a0a1c8b4567", strings.NewReader(`{"price": 900}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		res := httptest.NewRecorder()
+		e.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.True(t, *called)
+	})
+
+	t.Run("Test valid request reaches the handler", func(t *testing.T) {
+		e, called := newValidated(true, valid)
+		res := httptest.NewRecorder()
+		e.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/products/5f5e1f3f2c8b9a0a1c8b4567", nil))
+
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.True(t, *called)
+		assert.Contains(t, res.Body.String(), `"name":"alexa"`)
+	})
+
+	t.Run("Test route middleware runs before validation", func(t *testing.T) {
+		e := echo.New()
+		v := &Validator{}
+		auth := func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				if c.Request().Header.Get("X-Auth-Token") == "" {
+					return echo.ErrUnauthorized
+				}
+				return next(c)
+			}
+		}
+		e.POST("/products", func(c echo.Context) error { return c.NoContent(http.StatusCreated) }, middleware.BodyLimit("64B"), auth, v.Middleware)
+		v.Spec = Generate(e.Routes(), "test", "1.0.0")
+
+		post := func(body string, token string) int {
+			req := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set("X-Auth-Token", token)
+			res := httptest.NewRecorder()
+			e.ServeHTTP(res, req)
+			return res.Code
+		}
+		assert.Equal(t, http.StatusUnauthorized, post(`[{"name": 1}]`, ""))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, post(`[{"name": "`+strings.Repeat("a", 100)+`"}]`, "Bearer x"))
+		assert.Equal(t, http.StatusBadRequest, post(`[{"name": 1}]`, "Bearer x"))
+	})
+
+	t.Run("Test routes outside the spec pass through", func(t *testing.T) {
+		e, _ := newValidated(true, valid)
+		res := httptest.NewRecorder()
+		e.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "ok", res.Body.String())
+	})
+
+	t.Run("Test response drift is caught in dev mode", func(t *testing.T) {
+		drifted := valid
+		drifted.Currency = "DOLLARS"
+		e, _ := newValidated(true, drifted)
+		res := httptest.NewRecorder()
+		e.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/products/5f5e1f3f2c8b9a0a1c8b4567", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, res.Code)
+		errs := failures(t, res)
+		require.Len(t, errs, 1)
+		assert.Equal(t, "response", errs[0].In)
+		assert.Equal(t, "currency", errs[0].Field)
+
+		// without dev mode the response goes out as it is
+		e, _ = newValidated(false, drifted)
+		res = httptest.NewRecorder()
+		e.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/products/5f5e1f3f2c8b9a0a1c8b4567", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+	})
+}
+
+func TestVersions(t *testing.T) {
+	e := echo.New()
+	ph := &products.ProductsHandler{}
+	e.GET("/products/:id", ph.GetProduct)
+	e.GET("/v1/products/:id", ph.GetProduct)
+	e.GET("/v2/products/:id", ph.GetProductV2)
+	e.GET("/v2/products/:id/prices", ph.GetPrices)
+	spec := Generate(e.Routes(), "test", "1.0.0")
+
+	t.Run("Test v1 and the root are deprecated", func(t *testing.T) {
+		assert.True(t, spec.Paths["/products/{id}"].Get.Deprecated)
+		assert.True(t, spec.Paths["/v1/products/{id}"].Get.Deprecated)
+		assert.False(t, spec.Paths["/v2/products/{id}"].Get.Deprecated)
+	})
+
+	t.Run("Test versions get their own operations", func(t *testing.T) {
+		assert.Equal(t, "GetProduct", spec.Paths["/products/{id}"].Get.OperationID)
+		assert.Equal(t, "v1GetProduct", spec.Paths["/v1/products/{id}"].Get.OperationID)
+		assert.Equal(t, "v2GetProduct", spec.Paths["/v2/products/{id}"].Get.OperationID)
+		assert.Equal(t, []string{"products"}, spec.Paths["/v2/products/{id}"].Get.Tags)
+
+		v2 := spec.Paths["/v2/products/{id}"].Get.Responses.Get(http.StatusOK).Value
+		assert.Equal(t, "#/components/schemas/ProductV2", v2.Content.Get("application/json").Schema.Ref)
+		// routes v2 did not change are described once for every version
+		assert.Equal(t, "Price history of a product", spec.Paths["/v2/products/{id}/prices"].Get.Summary)
+		assert.Nil(t, spec.Validate(context.Background()))
+	})
+}
@@ -0,0 +1,228 @@
+package openapi
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// Violation is one way a request or a response differs from the specification
+type Violation struct {
+	// In is path, query, header, body or response
+	In string `json:"in"`
+	// Field is the parameter name or the JSON pointer inside the body
+	Field string `json:"field,omitempty"`
+	// Rule is the schema keyword that failed, such as pattern or maxLength
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Validator checks requests, and in dev mode responses, against the generated specification
+type Validator struct {
+	Spec *openapi3.T
+	// Responses turns on response checks, meant for dev mode and tests
+	Responses bool
+
+	once   sync.Once
+	router routers.Router
+	err    error
+}
+
+// Middleware rejects requests that do not match the specification before the handler runs,
+// it goes last on each route so the body limit and the JWT check come first.
+// Routes the specification does not describe are passed through untouched
+func (v *Validator) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		v.once.Do(func() {
+			v.router, v.err = gorillamux.NewRouter(v.Spec)
+		})
+		if v.err != nil {
+			log.Errorf("Unable to load the API specification: %v", v.err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Unable to load the API specification")
+		}
+
+		req := c.Request()
+		route, params, err := v.router.FindRoute(req)
+		if err != nil {
+			return next(c)
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: params,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+				// the document describes JSON bodies, XML and MessagePack ones are checked by the handlers
+				ExcludeRequestBody: req.ContentLength != 0 && !strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON),
+			},
+		}
+		if err := openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+			// the body limit of the route cut the body short
+			if errors.Is(err, echo.ErrStatusRequestEntityTooLarge) {
+				return echo.ErrStatusRequestEntityTooLarge
+			}
+			return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+				"message": "Request does not match the API specification",
+				"errors":  violations(err),
+			})
+		}
+
+		if !v.Responses {
+			return next(c)
+		}
+		return v.checkResponse(c, next, input)
+	}
+}
+
+// checkResponse buffers the response, validates it and only then writes it out,
+// an invalid response is replaced by a 500 that lists the violations
+func (v *Validator) checkResponse(c echo.Context, next echo.HandlerFunc, input *openapi3filter.RequestValidationInput) error {
+	res := c.Response()
+	rec := &recorder{ResponseWriter: res.Writer, status: http.StatusOK}
+	res.Writer = rec
+	defer func() { res.Writer = rec.ResponseWriter }()
+
+	// run the error handler here so error bodies are checked too
+	if err := next(c); err != nil {
+		c.Error(err)
+	}
+	if rec.streaming {
+		return nil
+	}
+
+	if err := validateResponse(c, input, rec); err != nil {
+		log.Errorf("Response of %s %s does not match the API specification: %v", input.Request.Method, input.Request.URL.Path, err)
+		res.Writer = rec.ResponseWriter
+		res.Committed, res.Size = false, 0
+		return echo.NewHTTPError(http.StatusInternalServerError, echo.Map{
+			"message": "Response does not match the API specification",
+			"errors":  violations(err),
+		})
+	}
+	return rec.flush()
+}
+
+func validateResponse(c echo.Context, input *openapi3filter.RequestValidationInput, rec *recorder) error {
+	if rec.body.Len() == 0 && (rec.status == http.StatusNoContent || rec.status == http.StatusNotModified) {
+		return nil
+	}
+	// alternative encodings such as CSV are not described by the specification
+	if !strings.HasPrefix(c.Response().Header().Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		return nil
+	}
+
+	out := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 rec.status,
+		Header:                 c.Response().Header(),
+		Options:                &openapi3filter.Options{MultiError: true, IncludeResponseStatus: true},
+	}
+	out.SetBodyBytes(rec.body.Bytes())
+	return openapi3filter.ValidateResponse(c.Request().Context(), out)
+}
+
+// violations flattens the errors of kin-openapi into a list clients can act on
+func violations(err error) []Violation {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		var list []Violation
+		for _, err := range e {
+			list = append(list, violations(err)...)
+		}
+		return list
+	case *openapi3filter.RequestError:
+		in, field := "body", ""
+		if e.Parameter != nil {
+			in, field = e.Parameter.In, e.Parameter.Name
+		}
+		if e.Err == nil {
+			return []Violation{{In: in, Field: field, Reason: e.Reason}}
+		}
+		return located(in, field, e.Err)
+	case *openapi3filter.ResponseError:
+		if e.Err == nil {
+			return []Violation{{In: "response", Reason: e.Reason}}
+		}
+		return located("response", "", e.Err)
+	}
+	return []Violation{{Reason: err.Error()}}
+}
+
+// located describes the schema failures found at one location
+func located(in string, field string, err error) []Violation {
+	if multi, ok := err.(openapi3.MultiError); ok {
+		var list []Violation
+		for _, err := range multi {
+			list = append(list, located(in, field, err)...)
+		}
+		return list
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		if pointer := strings.Join(schemaErr.JSONPointer(), "/"); pointer != "" {
+			field = strings.TrimPrefix(field+"/"+pointer, "/")
+		}
+		return []Violation{{In: in, Field: field, Rule: schemaErr.SchemaField, Reason: schemaErr.Reason}}
+	}
+	return []Violation{{In: in, Field: field, Reason: err.Error()}}
+}
+
+// recorder holds the response back until it is validated, streams (SSE, WebSocket) bypass it
+// as soon as they flush or hijack the connection
+type recorder struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	streaming bool
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.streaming {
+		r.ResponseWriter.WriteHeader(code)
+		return
+	}
+	r.status = code
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.streaming {
+		return r.ResponseWriter.Write(b)
+	}
+	return r.body.Write(b)
+}
+
+func (r *recorder) Flush() {
+	if !r.streaming {
+		r.flush()
+		r.streaming = true
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.streaming = true
+	return r.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// flush writes the buffered status and body through
+func (r *recorder) flush() error {
+	r.ResponseWriter.WriteHeader(r.status)
+	_, err := r.ResponseWriter.Write(r.body.Bytes())
+	return err
+}
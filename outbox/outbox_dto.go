@@ -0,0 +1,26 @@
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Event is a domain event written in the same transaction as the change it describes,
+// its ID doubles as the dedup id sinks and receivers can use to drop redeliveries
+type Event struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Type        string             `json:"type" bson:"type"`
+	AggregateID string             `json:"aggregate_id" bson:"aggregate_id"`
+	Payload     json.RawMessage    `json:"payload" bson:"payload"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	PublishedAt *time.Time         `json:"published_at,omitempty" bson:"published_at"`
+	Delivered   []string           `json:"-" bson:"delivered,omitempty"`
+	Attempts    int                `json:"-" bson:"attempts,omitempty"`
+	LastError   string             `json:"-" bson:"last_error,omitempty"`
+	// LockedUntil is set while a relay publishes the event
+	LockedUntil *time.Time `json:"-" bson:"locked_until,omitempty"`
+	// DeadAt is set once the event ran out of attempts, it is no longer published
+	DeadAt *time.Time `json:"-" bson:"dead_at,omitempty"`
+}
@@ -0,0 +1,24 @@
+package outbox
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// CollectionAPI collection interface
+	CollectionAPI interface {
+		InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+		FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+		UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	}
+
+	// Sink is somewhere the relay publishes events to, Name must be stable as it is
+	// stored with each event to remember which sinks already have it
+	Sink interface {
+		Name() string
+		Publish(ctx context.Context, event Event) error
+	}
+)
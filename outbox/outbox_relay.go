@@ -0,0 +1,124 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Relay publishes pending outbox events to its sinks, an event stays pending
+// until every sink has it, so a sink may see an event more than once but never misses one
+type Relay struct {
+	Coll     CollectionAPI
+	Sinks    []Sink
+	Interval time.Duration
+	Batch    int
+	// MaxAttempts is how often an event is tried before it is dead-lettered and skipped
+	MaxAttempts int
+	// Lock is how long an event claimed by a relay is left alone by the others
+	Lock time.Duration
+}
+
+// deliver publishes an event to the sinks that do not have it yet, in order,
+// and returns the sinks that have it afterwards
+func deliver(ctx context.Context, event Event, sinks []Sink) ([]string, error) {
+	delivered := append([]string{}, event.Delivered...)
+	done := map[string]bool{}
+	for _, name := range delivered {
+		done[name] = true
+	}
+
+	for _, sink := range sinks {
+		if done[sink.Name()] {
+			continue
+		}
+		if err := sink.Publish(ctx, event); err != nil {
+			return delivered, err
+		}
+		delivered = append(delivered, sink.Name())
+	}
+	return delivered, nil
+}
+
+// claimFilter matches the pending events no relay holds
+func claimFilter(now time.Time) bson.M {
+	return bson.M{
+		"published_at": nil,
+		"dead_at":      nil,
+		"$or":          bson.A{bson.M{"locked_until": nil}, bson.M{"locked_until": bson.M{"$lte": now}}},
+	}
+}
+
+// failedUpdate records a failed attempt, the event is dead-lettered on its last one
+func failedUpdate(event Event, delivered []string, err error, maxAttempts int, now time.Time) bson.M {
+	set := bson.M{"delivered": delivered, "last_error": err.Error()}
+	if event.Attempts+1 >= maxAttempts {
+		set["dead_at"] = now
+	}
+	return bson.M{"$set": set, "$inc": bson.M{"attempts": 1}, "$unset": bson.M{"locked_until": ""}}
+}
+
+// relay publishes up to a batch of pending events oldest first, stopping at the first failure
+// so the relay's later events never overtake an earlier one. Each event is claimed before it is
+// published, so several relays never publish the same event at once, although one relay may
+// publish an event while another still retries an older one
+func (r *Relay) relay(ctx context.Context) (int, error) {
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	for i := 0; i < r.Batch; i++ {
+		now := time.Now().UTC()
+		var event Event
+		err := r.Coll.FindOneAndUpdate(ctx, claimFilter(now), bson.M{"$set": bson.M{"locked_until": now.Add(r.Lock)}}, opts).Decode(&event)
+		if err == mongo.ErrNoDocuments {
+			return i, nil
+		}
+		if err != nil {
+			return i, err
+		}
+
+		delivered, err := deliver(ctx, event, r.Sinks)
+
+		update := bson.M{
+			"$set":   bson.M{"delivered": delivered, "published_at": time.Now().UTC()},
+			"$unset": bson.M{"locked_until": ""},
+		}
+		if err != nil {
+			update = failedUpdate(event, delivered, err, r.MaxAttempts, time.Now().UTC())
+			if event.Attempts+1 >= r.MaxAttempts {
+				log.Errorf("Dead-lettering outbox event %s after %d attempts: %v", event.ID.Hex(), event.Attempts+1, err)
+			}
+		}
+
+		if _, uErr := r.Coll.UpdateOne(ctx, bson.M{"_id": event.ID}, update); uErr != nil {
+			return i, uErr
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+	return r.Batch, nil
+}
+
+// Run relays pending events every interval until ctx is done
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.relay(ctx); err != nil {
+			log.Errorf("Unable to relay outbox events: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Store records events in the outbox collection inside the caller's transaction,
+// it needs a replica set or mongos as writes and their events must commit together.
+// A nil Store stands for no outbox, it runs fn straight away and records nothing
+type Store struct {
+	Coll   CollectionAPI
+	Client *mongo.Client
+}
+
+// Transact runs fn in a transaction, every write made with the ctx given to fn commits or aborts together
+func (s *Store) Transact(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s == nil {
+		return fn(ctx)
+	}
+
+	session, err := s.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// Record adds a pending event, ctx should be the one given to Transact
+func (s *Store) Record(ctx context.Context, eventType string, aggregateID string, payload interface{}) error {
+	return s.record(ctx, eventType, aggregateID, payload, false)
+}
+
+// RecordInternal adds an event that never leaves the service, it is recorded as published so the relay
+// skips it while change streams on the outbox still see it
+func (s *Store) RecordInternal(ctx context.Context, eventType string, aggregateID string, payload interface{}) error {
+	return s.record(ctx, eventType, aggregateID, payload, true)
+}
+
+func (s *Store) record(ctx context.Context, eventType string, aggregateID string, payload interface{}, internal bool) error {
+	if s == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	event := Event{
+		ID:          primitive.NewObjectID(),
+		Type:        eventType,
+		AggregateID: aggregateID,
+		Payload:     b,
+		CreatedAt:   now,
+	}
+	if internal {
+		event.PublishedAt = &now
+	}
+	_, err = s.Coll.InsertOne(ctx, event)
+	return err
+}
+
+// SupportsTransactions reports whether the server is a replica set member or mongos
+func SupportsTransactions(ctx context.Context, client *mongo.Client) bool {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
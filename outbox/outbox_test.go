@@ -0,0 +1,194 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// flakySink fails until it is healed, counting what it received
+// insertedColl keeps the events inserted into it
+type insertedColl struct {
+	CollectionAPI
+	events []Event
+}
+
+func (c *insertedColl) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	c.events = append(c.events, document.(Event))
+	return &mongo.InsertOneResult{}, nil
+}
+
+type flakySink struct {
+	name     string
+	healthy  bool
+	received []primitive.ObjectID
+}
+
+func (s *flakySink) Name() string {
+	return s.name
+}
+
+func (s *flakySink) Publish(ctx context.Context, event Event) error {
+	if !s.healthy {
+		return errors.New("unavailable")
+	}
+	s.received = append(s.received, event.ID)
+	return nil
+}
+
+func newEvent() Event {
+	return Event{
+		ID:          primitive.NewObjectID(),
+		Type:        "product.created",
+		AggregateID: "60f1a6b2c3d4e5f6a7b8c9d0",
+		Payload:     json.RawMessage(`{"product_name":"iphone"}`),
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	t.Run("Test event survives a bson round trip", func(t *testing.T) {
+		event := newEvent()
+		b, err := bson.Marshal(event)
+		require.NoError(t, err)
+
+		var decoded Event
+		require.NoError(t, bson.Unmarshal(b, &decoded))
+		assert.Equal(t, event.ID, decoded.ID)
+		assert.JSONEq(t, string(event.Payload), string(decoded.Payload))
+		assert.Nil(t, decoded.PublishedAt)
+	})
+
+	t.Run("Test deliver skips sinks that already have the event", func(t *testing.T) {
+		bus := &flakySink{name: "bus", healthy: true}
+		hook := &flakySink{name: "webhook"}
+		event := newEvent()
+
+		delivered, err := deliver(context.Background(), event, []Sink{bus, hook})
+		assert.Error(t, err)
+		assert.Equal(t, []string{"bus"}, delivered)
+
+		// the retry only goes to the sink that failed
+		hook.healthy = true
+		event.Delivered = delivered
+		delivered, err = deliver(context.Background(), event, []Sink{bus, hook})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"bus", "webhook"}, delivered)
+		assert.Len(t, bus.received, 1)
+		assert.Equal(t, []primitive.ObjectID{event.ID}, hook.received)
+	})
+
+	t.Run("Test events are dead-lettered on their last attempt", func(t *testing.T) {
+		now := time.Now().UTC()
+		event := newEvent()
+		event.Attempts = 3
+
+		update := failedUpdate(event, []string{"bus"}, errors.New("down"), 5, now)
+		assert.NotContains(t, update["$set"], "dead_at")
+		assert.Equal(t, bson.M{"locked_until": ""}, update["$unset"])
+
+		event.Attempts = 4
+		update = failedUpdate(event, []string{"bus"}, errors.New("down"), 5, now)
+		assert.Equal(t, now, update["$set"].(bson.M)["dead_at"])
+	})
+
+	t.Run("Test claimed and dead events are not claimed", func(t *testing.T) {
+		filter := claimFilter(time.Now())
+		assert.Nil(t, filter["published_at"])
+		assert.Nil(t, filter["dead_at"])
+		assert.Len(t, filter["$or"], 2)
+	})
+
+	t.Run("Test a nil store runs writes without recording", func(t *testing.T) {
+		var store *Store
+		ran := false
+		err := store.Transact(context.Background(), func(ctx context.Context) error {
+			ran = true
+			return store.Record(ctx, "product.created", "1", nil)
+		})
+		assert.Nil(t, err)
+		assert.True(t, ran)
+	})
+
+	t.Run("Test internal events are never claimed by the relay", func(t *testing.T) {
+		coll := &insertedColl{}
+		store := &Store{Coll: coll}
+		assert.Nil(t, store.Record(context.Background(), "product.created", "1", nil))
+		assert.Nil(t, store.RecordInternal(context.Background(), "product.created", "2", nil))
+		require.Len(t, coll.events, 2)
+		assert.Nil(t, coll.events[0].PublishedAt)
+		assert.NotNil(t, coll.events[1].PublishedAt)
+	})
+
+	t.Run("Test bus hands events to subscribers", func(t *testing.T) {
+		bus := &Bus{}
+		var got []Event
+		bus.Subscribe(func(ctx context.Context, event Event) error {
+			got = append(got, event)
+			return nil
+		})
+
+		event := newEvent()
+		assert.NoError(t, bus.Publish(context.Background(), event))
+		assert.Equal(t, []Event{event}, got)
+
+		bus.Subscribe(func(ctx context.Context, event Event) error {
+			return errors.New("down")
+		})
+		assert.Error(t, bus.Publish(context.Background(), event))
+	})
+
+	t.Run("Test file sink appends json lines", func(t *testing.T) {
+		sink := &FileSink{Path: filepath.Join(t.TempDir(), "events.jsonl")}
+		first, second := newEvent(), newEvent()
+		require.NoError(t, sink.Publish(context.Background(), first))
+		require.NoError(t, sink.Publish(context.Background(), second))
+
+		f, err := os.Open(sink.Path)
+		require.NoError(t, err)
+		defer f.Close()
+
+		var ids []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var line struct {
+				ID string `json:"id"`
+			}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			ids = append(ids, line.ID)
+		}
+		assert.Equal(t, []string{first.ID.Hex(), second.ID.Hex()}, ids)
+	})
+
+	t.Run("Test webhook sink sends the dedup id", func(t *testing.T) {
+		var ids []string
+		status := http.StatusServiceUnavailable
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ids = append(ids, r.Header.Get(HeaderEventID))
+			w.WriteHeader(status)
+		}))
+		defer receiver.Close()
+
+		sink := &WebhookSink{URL: receiver.URL, Client: receiver.Client()}
+		event := newEvent()
+		assert.Error(t, sink.Publish(context.Background(), event))
+
+		status = http.StatusOK
+		assert.NoError(t, sink.Publish(context.Background(), event))
+		assert.Equal(t, []string{event.ID.Hex(), event.ID.Hex()}, ids)
+	})
+}
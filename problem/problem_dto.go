@@ -0,0 +1,54 @@
+package problem
+
+import "encoding/json"
+
+const (
+	MIMEProblemJSON = "application/problem+json"
+	// TypeBlank is the RFC 7807 type of a problem described by its status alone
+	TypeBlank = "about:blank"
+)
+
+// Problem is an RFC 7807 problem detail, Extensions are written next to the standard members
+type Problem struct {
+	Type          string                 `json:"type"`
+	Title         string                 `json:"title"`
+	Status        int                    `json:"status"`
+	Detail        string                 `json:"detail,omitempty"`
+	Instance      string                 `json:"instance,omitempty"`
+	CorrelationID string                 `json:"correlation_id,omitempty"`
+	Errors        []FieldError           `json:"errors,omitempty"`
+	Extensions    map[string]interface{} `json:"-"`
+}
+
+// FieldError is one failed rule, from the validator of a DTO or from the API specification
+type FieldError struct {
+	// In is body for DTO fields, or the part of the request the API specification rejected
+	In string `json:"in,omitempty"`
+	// Field is the JSON pointer inside the body (discount_rules/0/min_quantity) or the parameter name
+	Field string `json:"field,omitempty"`
+	// Rule is the validator tag or schema keyword that failed, such as required or max
+	Rule string `json:"rule,omitempty"`
+	// Param is the argument of the rule, 10 for max=10
+	Param  string `json:"param,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// MarshalJSON writes the extension members at the top level as RFC 7807 asks
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	b, err := json.Marshal(problem(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return b, err
+	}
+
+	var members map[string]interface{}
+	if err := json.Unmarshal(b, &members); err != nil {
+		return nil, err
+	}
+	for key, value := range p.Extensions {
+		if _, ok := members[key]; !ok {
+			members[key] = value
+		}
+	}
+	return json.Marshal(members)
+}
@@ -0,0 +1,127 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"learning-golang-restful-api/domainerr"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator"
+	"github.com/labstack/echo"
+)
+
+const HeaderCorrelationID = "X-Correlation-Id"
+
+// ErrorHandler answers every error with an application/problem+json body
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	p := New(c, err)
+	body, err := json.Marshal(p)
+	if err == nil {
+		if c.Request().Method == http.MethodHead {
+			err = c.NoContent(p.Status)
+		} else {
+			err = c.Blob(p.Status, MIMEProblemJSON, body)
+		}
+	}
+	if err != nil {
+		c.Echo().Logger.Error(err)
+	}
+}
+
+// New describes err as a problem, the message of an HTTP or domain error becomes its detail, a message
+// listing violations (in, field, reason) its field errors, and validator failures behind the error too
+func New(c echo.Context, err error) Problem {
+	code := http.StatusInternalServerError
+	var message interface{}
+	var internal error
+	var de *domainerr.Error
+	if he, ok := err.(*echo.HTTPError); ok {
+		code, message, internal = he.Code, he.Message, he.Internal
+	} else if errors.As(err, &de) {
+		code, message, internal = Status(de.Kind), de.Message, de.Err
+	}
+
+	req := c.Request()
+	p := Problem{
+		Type:          TypeBlank,
+		Title:         http.StatusText(code),
+		Status:        code,
+		Instance:      req.URL.Path,
+		CorrelationID: req.Header.Get(HeaderCorrelationID),
+	}
+
+	switch m := message.(type) {
+	case nil:
+	case string:
+		p.Detail = m
+	case error:
+		p.Detail = m.Error()
+	default:
+		members(&p, m)
+	}
+	p.Errors = append(p.Errors, Fields(internal)...)
+	return p
+}
+
+// members spreads a map message over the problem, message is the detail, errors the field errors
+// and anything else such as the remaining quota of a purchase stays as an extension
+func members(p *Problem, message interface{}) {
+	b, err := json.Marshal(message)
+	var fields map[string]json.RawMessage
+	if err != nil || json.Unmarshal(b, &fields) != nil {
+		p.Detail = fmt.Sprint(message)
+		return
+	}
+
+	for key, raw := range fields {
+		switch key {
+		case "message":
+			if json.Unmarshal(raw, &p.Detail) == nil {
+				continue
+			}
+		case "errors":
+			if json.Unmarshal(raw, &p.Errors) == nil {
+				continue
+			}
+		}
+		if p.Extensions == nil {
+			p.Extensions = map[string]interface{}{}
+		}
+		var value interface{}
+		json.Unmarshal(raw, &value)
+		p.Extensions[key] = value
+	}
+}
+
+// Fields lists the validator failures in err, field names are the JSON names of a validator from NewValidator
+func Fields(err error) []FieldError {
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return nil
+	}
+
+	list := make([]FieldError, 0, len(invalid))
+	for _, fe := range invalid {
+		reason := "failed on " + fe.Tag()
+		if fe.Param() != "" {
+			reason += "=" + fe.Param()
+		}
+		list = append(list, FieldError{In: "body", Field: pointer(fe.Namespace()), Rule: fe.Tag(), Param: fe.Param(), Reason: reason})
+	}
+	return list
+}
+
+// pointer turns Product.discount_rules[0].min_quantity into discount_rules/0/min_quantity
+func pointer(namespace string) string {
+	if i := strings.Index(namespace, "."); i >= 0 {
+		namespace = namespace[i+1:]
+	}
+	namespace = strings.NewReplacer("[", "/", "]", "", ".", "/").Replace(namespace)
+	return namespace
+}
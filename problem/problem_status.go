@@ -0,0 +1,25 @@
+package problem
+
+import (
+	"learning-golang-restful-api/domainerr"
+	"net/http"
+)
+
+// statuses is the one place domain errors are translated to HTTP
+var statuses = map[domainerr.Kind]int{
+	domainerr.Internal:        http.StatusInternalServerError,
+	domainerr.InvalidArgument: http.StatusBadRequest,
+	domainerr.NotFound:        http.StatusNotFound,
+	domainerr.Conflict:        http.StatusConflict,
+	domainerr.Unauthorized:    http.StatusUnauthorized,
+	domainerr.Forbidden:       http.StatusForbidden,
+	domainerr.Unavailable:     http.StatusServiceUnavailable,
+}
+
+// Status is the HTTP status of a domain error kind
+func Status(kind domainerr.Kind) int {
+	if status, ok := statuses[kind]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,96 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"learning-golang-restful-api/domainerr"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type rule struct {
+	MinQuantity int `json:"min_quantity" validate:"min=1"`
+}
+
+type product struct {
+	Name  string `json:"name" validate:"required,max=10"`
+	Rules []rule `json:"discount_rules" validate:"dive"`
+}
+
+func context(method string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, "/products/1", nil)
+	req.Header.Set(HeaderCorrelationID, "abc")
+	res := httptest.NewRecorder()
+	return echo.New().NewContext(req, res), res
+}
+
+func TestProblem(t *testing.T) {
+	t.Run("Test string messages are the detail", func(t *testing.T) {
+		c, res := context(http.MethodGet)
+		ErrorHandler(echo.NewHTTPError(http.StatusNotFound, "Product does not exists"), c)
+
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		assert.Equal(t, MIMEProblemJSON, res.Header().Get(echo.HeaderContentType))
+		assert.JSONEq(t, `{
+			"type": "about:blank",
+			"title": "Not Found",
+			"status": 404,
+			"detail": "Product does not exists",
+			"instance": "/products/1",
+			"correlation_id": "abc"
+		}`, res.Body.String())
+	})
+
+	t.Run("Test validator failures are listed per field", func(t *testing.T) {
+		err := NewValidator().Struct(product{Name: "a very long name", Rules: []rule{{MinQuantity: 0}}})
+		c, _ := context(http.MethodPost)
+
+		p := New(c, echo.NewHTTPError(http.StatusBadRequest, "Unable to validate the product").SetInternal(err))
+		assert.Equal(t, "Unable to validate the product", p.Detail)
+		assert.Equal(t, []FieldError{
+			{In: "body", Field: "name", Rule: "max", Param: "10", Reason: "failed on max=10"},
+			{In: "body", Field: "discount_rules/0/min_quantity", Rule: "min", Param: "1", Reason: "failed on min=1"},
+		}, p.Errors)
+	})
+
+	t.Run("Test map messages keep their violations and extensions", func(t *testing.T) {
+		c, res := context(http.MethodPost)
+		ErrorHandler(echo.NewHTTPError(http.StatusTooManyRequests, echo.Map{
+			"message":   "Purchase limit reached",
+			"remaining": 0,
+			"errors":    []echo.Map{{"in": "query", "field": "limit", "rule": "maximum", "reason": "too big"}},
+		}), c)
+
+		var body map[string]interface{}
+		require.Nil(t, json.Unmarshal(res.Body.Bytes(), &body))
+		assert.Equal(t, "Purchase limit reached", body["detail"])
+		assert.EqualValues(t, 0, body["remaining"])
+		assert.Equal(t, []interface{}{map[string]interface{}{"in": "query", "field": "limit", "rule": "maximum", "reason": "too big"}}, body["errors"])
+	})
+
+	t.Run("Test domain errors take the status of their kind", func(t *testing.T) {
+		c, _ := context(http.MethodGet)
+		p := New(c, domainerr.New(domainerr.Conflict, "Product already exists"))
+		assert.Equal(t, http.StatusConflict, p.Status)
+		assert.Equal(t, "Product already exists", p.Detail)
+	})
+
+	t.Run("Test other errors are internal", func(t *testing.T) {
+		c, _ := context(http.MethodGet)
+		p := New(c, errors.New("connection refused"))
+		assert.Equal(t, http.StatusInternalServerError, p.Status)
+		assert.Empty(t, p.Detail)
+	})
+
+	t.Run("Test HEAD has no body", func(t *testing.T) {
+		c, res := context(http.MethodHead)
+		ErrorHandler(echo.ErrForbidden, c)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+		assert.Empty(t, res.Body.String())
+	})
+}
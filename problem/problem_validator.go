@@ -0,0 +1,24 @@
+package problem
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator"
+)
+
+// NewValidator is a validator that names fields by their json tag, so failures point into the body
+func NewValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	return v
+}
@@ -0,0 +1,118 @@
+package products
+
+import (
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"reflect"
+	"strings"
+
+	"github.com/labstack/gommon/log"
+)
+
+// fieldAccess describes the roles that may read or write a Product field, declared by its access tag
+// e.g. `access:"read=admin|auditor,write=admin"`, a missing role list means everyone
+type fieldAccess struct {
+	index int
+	name  string
+	read  []string
+	write []string
+}
+
+var productAccess = parseAccess(reflect.TypeOf(Product{}))
+
+func parseAccess(t reflect.Type) []fieldAccess {
+	var fields []fieldAccess
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("access")
+		if !ok {
+			continue
+		}
+
+		field := fieldAccess{index: i, name: strings.Split(t.Field(i).Tag.Get("json"), ",")[0]}
+		for _, rule := range strings.Split(tag, ",") {
+			parts := strings.SplitN(rule, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			roles := strings.Split(parts[1], "|")
+			switch parts[0] {
+			case "read":
+				field.read = roles
+			case "write":
+				field.write = roles
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func hasAnyRole(claims auth.Claims, roles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		if claims.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// shapeProduct clears the fields the caller may not read
+func shapeProduct(product *Product, claims auth.Claims) {
+	value := reflect.ValueOf(product).Elem()
+	for _, field := range productAccess {
+		if !hasAnyRole(claims, field.read) {
+			f := value.Field(field.index)
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+}
+
+func shapeProducts(products []Product, claims auth.Claims) {
+	for i := range products {
+		shapeProduct(&products[i], claims)
+	}
+}
+
+// checkWrites rejects changes to the fields the caller may not write, before is the zero Product on create
+func checkWrites(before, after Product, claims auth.Claims) *domainerr.Error {
+	var denied []string
+	b, a := reflect.ValueOf(before), reflect.ValueOf(after)
+	for _, field := range productAccess {
+		if hasAnyRole(claims, field.write) {
+			continue
+		}
+		if !reflect.DeepEqual(b.Field(field.index).Interface(), a.Field(field.index).Interface()) {
+			denied = append(denied, field.name)
+		}
+	}
+
+	if len(denied) > 0 {
+		log.Errorf("%s is not allowed to write %v", claims.UserID, denied)
+		return domainerr.New(domainerr.Forbidden, "Not allowed to write "+strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// checkReadable rejects filtering on a field the caller may not read
+func checkReadable(name string, claims auth.Claims) *domainerr.Error {
+	for _, field := range productAccess {
+		if field.name == name && !hasAnyRole(claims, field.read) {
+			return domainerr.New(domainerr.Forbidden, "Not allowed to read "+name)
+		}
+	}
+	return nil
+}
+
+// canModify allows changes by the product's creator or by an admin,
+// products without a creator are only modified by admins
+func canModify(claims auth.Claims, product Product) *domainerr.Error {
+	if claims.IsAdmin || (claims.UserID != "" && product.CreatedBy == claims.UserID) {
+		return nil
+	}
+
+	log.Errorf("%s is not allowed to modify product %s", claims.UserID, product.ID.Hex())
+	return domainerr.New(domainerr.Forbidden, "Only the creator or an admin can modify the product")
+}
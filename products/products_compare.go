@@ -0,0 +1,192 @@
+package products
+
+import (
+	"context"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/negotiation"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Comparison describes products side by side
+type Comparison struct {
+	Products    []Product           `json:"products"`
+	Differing   []string            `json:"differing"`
+	Matching    []string            `json:"matching"`
+	Accessories []AccessoryPresence `json:"accessories"`
+	Currency    string              `json:"currency"`
+	Prices      []NormalizedPrice   `json:"prices"`
+}
+
+// AccessoryPresence tells which of the compared products include an accessory, in product order
+type AccessoryPresence struct {
+	Name    string `json:"name"`
+	Present []bool `json:"present"`
+}
+
+// NormalizedPrice describes a product's prices in the comparison currency, nil when there is no exchange rate
+type NormalizedPrice struct {
+	ProductID      primitive.ObjectID `json:"product_id"`
+	Price          *float64           `json:"price"`
+	EffectivePrice *float64           `json:"effective_price"`
+}
+
+// uncomparedFields are bookkeeping fields that say nothing about the product itself
+var uncomparedFields = map[string]bool{
+	"_id":            true,
+	"created_by":     true,
+	"updated_by":     true,
+	"created_at":     true,
+	"updated_at":     true,
+	"status_history": true,
+	"publish_at":     true,
+}
+
+// convertPrice converts amount between currencies, rates are units of each currency per common unit
+func convertPrice(amount int, from, to string, rates map[string]float64) *float64 {
+	fromRate, ok := rates[from]
+	if !ok || fromRate == 0 {
+		return nil
+	}
+	toRate, ok := rates[to]
+	if !ok {
+		return nil
+	}
+
+	converted := math.Round(float64(amount)/fromRate*toRate*100) / 100
+	return &converted
+}
+
+// compareFields splits the readable json fields into those that differ between products and those that match
+func compareFields(products []Product, claims auth.Claims) (differing, matching []string) {
+	differing, matching = []string{}, []string{}
+	restricted := make(map[string]bool)
+	for _, field := range productAccess {
+		if !hasAnyRole(claims, field.read) {
+			restricted[field.name] = true
+		}
+	}
+
+	t := reflect.TypeOf(Product{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || uncomparedFields[name] || restricted[name] {
+			continue
+		}
+
+		first := reflect.ValueOf(products[0]).Field(i).Interface()
+		same := true
+		for _, product := range products[1:] {
+			if !reflect.DeepEqual(first, reflect.ValueOf(product).Field(i).Interface()) {
+				same = false
+				break
+			}
+		}
+
+		if same {
+			matching = append(matching, name)
+		} else {
+			differing = append(differing, name)
+		}
+	}
+	return differing, matching
+}
+
+// compareAccessories lists every accessory once, in the order first seen
+func compareAccessories(products []Product) []AccessoryPresence {
+	accessories := []AccessoryPresence{}
+	index := make(map[string]int)
+	for i, product := range products {
+		for _, name := range product.Accessories {
+			j, ok := index[name]
+			if !ok {
+				j = len(accessories)
+				index[name] = j
+				accessories = append(accessories, AccessoryPresence{Name: name, Present: make([]bool, len(products))})
+			}
+			accessories[j].Present[i] = true
+		}
+	}
+	return accessories
+}
+
+func compareProducts(ctx context.Context, ids string, claims auth.Claims, max int, currency string, rates map[string]float64, coll CollectionAPI) (*Comparison, *domainerr.Error) {
+	comparison := &Comparison{}
+
+	var _ids []primitive.ObjectID
+	seen := make(map[primitive.ObjectID]bool)
+	for _, id := range strings.Split(ids, ",") {
+		_id, err := primitive.ObjectIDFromHex(strings.TrimSpace(id))
+		if err != nil {
+			log.Errorf("Unable to convert id to _id: %v", err)
+			return comparison, domainerr.New(domainerr.InvalidArgument, "Unable to convert id to _id")
+		}
+		if !seen[_id] {
+			seen[_id] = true
+			_ids = append(_ids, _id)
+		}
+	}
+
+	if len(_ids) < 2 || len(_ids) > max {
+		return comparison, domainerr.New(domainerr.InvalidArgument, "Compare between 2 and "+strconv.Itoa(max)+" products")
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": _ids}}
+	visibleFilter(filter, claims)
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		log.Errorf("Unable to find products : %v", err)
+		return comparison, domainerr.New(domainerr.Internal, "Unable to find products")
+	}
+
+	var found []Product
+	if err := cursor.All(ctx, &found); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return comparison, domainerr.New(domainerr.Internal, "Unable to read the cursor")
+	}
+
+	// keep the order the products were asked for
+	byID := make(map[primitive.ObjectID]Product)
+	for _, product := range found {
+		byID[product.ID] = product
+	}
+	for _, _id := range _ids {
+		product, ok := byID[_id]
+		if !ok {
+			return comparison, domainerr.New(domainerr.NotFound, "Product "+_id.Hex()+" does not exists")
+		}
+		comparison.Products = append(comparison.Products, product)
+	}
+
+	shapeProducts(comparison.Products, claims)
+	comparison.Differing, comparison.Matching = compareFields(comparison.Products, claims)
+	comparison.Accessories = compareAccessories(comparison.Products)
+	comparison.Currency = currency
+	for _, product := range comparison.Products {
+		comparison.Prices = append(comparison.Prices, NormalizedPrice{
+			ProductID:      product.ID,
+			Price:          convertPrice(product.Price, product.Currency, currency, rates),
+			EffectivePrice: convertPrice(product.EffectivePrice(), product.Currency, currency, rates),
+		})
+	}
+
+	return comparison, nil
+}
+
+func (h *ProductsHandler) CompareProducts(c echo.Context) error {
+	comparison, err := compareProducts(context.Background(), c.QueryParam("ids"), auth.FromContext(c), h.CompareMax, h.CompareCurrency, h.ExchangeRates, h.Coll)
+	if err != nil {
+		return err
+	}
+
+	return negotiation.Write(c, http.StatusOK, comparison)
+}
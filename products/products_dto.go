@@ -1,24 +1,64 @@
 package products
 
 import (
+	"encoding/xml"
+	"learning-golang-restful-api/problem"
+	"time"
+
 	"github.com/go-playground/validator"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var (
-	v = validator.New()
+	v = problem.NewValidator()
 )
 
 // Product describes an electronic product e.g. phone
 type Product struct {
-	ID          primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	Name        string             `json:"name" bson:"name" validate:"required,max=10"`
-	Price       int                `json:"price" bson:"price" validate:"required,max=2000"`
-	Currency    string             `json:"currency" bson:"currency" validate:"required,len=3"`
-	Discount    int                `json:"discount" bson:"discount"`
-	Vendor      string             `json:"vendor" bson:"vendor" validate:"required"`
-	Accessories []string           `json:"accessories,omitempty" bson:"accessories,omitempty" validate:"required"`
-	IsEssential bool               `json:"is_essential" bson:"is_essential"`
+	ID            primitive.ObjectID `json:"_id,omitempty" xml:"-" bson:"_id,omitempty"`
+	Name          string             `json:"name" xml:"name" bson:"name" validate:"required,max=10"`
+	Price         int                `json:"price" xml:"price" bson:"price" validate:"required,max=2000"`
+	Currency      string             `json:"currency" xml:"currency" bson:"currency" validate:"required,len=3"`
+	Discount      int                `json:"discount" xml:"discount" bson:"discount"`
+	Vendor        string             `json:"vendor" xml:"vendor" bson:"vendor" validate:"required"`
+	Accessories   []string           `json:"accessories,omitempty" xml:"accessories>accessory,omitempty" bson:"accessories,omitempty" validate:"required"`
+	IsEssential   bool               `json:"is_essential" xml:"is_essential" bson:"is_essential"`
+	PurchaseLimit int                `json:"purchase_limit,omitempty" xml:"purchase_limit,omitempty" bson:"purchase_limit,omitempty" validate:"min=0"`
+	CostPrice     int                `json:"cost_price,omitempty" xml:"cost_price,omitempty" bson:"cost_price,omitempty" validate:"min=0" access:"read=admin,write=admin"`
+	InternalNotes string             `json:"internal_notes,omitempty" xml:"internal_notes,omitempty" bson:"internal_notes,omitempty" access:"read=admin,write=admin"`
+	DiscountRules []DiscountRule     `json:"discount_rules,omitempty" xml:"discount_rules>discount_rule,omitempty" bson:"discount_rules,omitempty" validate:"dive" access:"read=admin,write=admin"`
+	CreatedBy     string             `json:"created_by,omitempty" xml:"created_by,omitempty" bson:"created_by,omitempty"`
+	UpdatedBy     string             `json:"updated_by,omitempty" xml:"updated_by,omitempty" bson:"updated_by,omitempty"`
+	Status        string             `json:"status" xml:"status" bson:"status" validate:"omitempty,oneof=draft scheduled published archived"`
+	PublishAt     *time.Time         `json:"publish_at,omitempty" xml:"publish_at,omitempty" bson:"publish_at,omitempty"`
+	StatusHistory []StatusChange     `json:"status_history,omitempty" xml:"status_history>status_change,omitempty" bson:"status_history,omitempty"`
+	CreatedAt     time.Time          `json:"created_at" xml:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at" xml:"updated_at" bson:"updated_at"`
+}
+
+// DiscountRule describes a discount granted from a minimum quantity
+type DiscountRule struct {
+	MinQuantity int `json:"min_quantity" xml:"min_quantity" bson:"min_quantity" validate:"min=1"`
+	Discount    int `json:"discount" xml:"discount" bson:"discount" validate:"min=0,max=100"`
+}
+
+// MarshalXML writes a product as <product>, the id goes out as hex since ObjectID has no text form
+func (p Product) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type product Product
+	var id string
+	if !p.ID.IsZero() {
+		id = p.ID.Hex()
+	}
+	start.Name.Local = "product"
+	return e.EncodeElement(struct {
+		ID string `xml:"_id,omitempty"`
+		product
+	}{id, product(p)}, start)
+}
+
+// EffectivePrice is the price after the percentage discount is applied
+func (p Product) EffectivePrice() int {
+	return p.Price * (100 - p.Discount) / 100
 }
 
 // ProductValidator a product validator
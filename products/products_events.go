@@ -0,0 +1,433 @@
+package products
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"learning-golang-restful-api/auth"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errNoChangeStream is returned when resuming from a token while no change stream feeds the broker
+var errNoChangeStream = errors.New("no change stream")
+
+const (
+	EventCreated = "product.created"
+	EventUpdated = "product.updated"
+	EventDeleted = "product.deleted"
+
+	// subscriberBuffer is how many events a slow subscriber may lag behind before it misses some
+	subscriberBuffer = 64
+	// keepAliveInterval stops proxies from closing an idle stream
+	keepAliveInterval = 15 * time.Second
+)
+
+// Event describes a change to a product
+type Event struct {
+	ID uint64 `json:"id"`
+	// Token is the change stream resume token of the event, Last-Event-ID resumes after it on any instance
+	Token     string             `json:"token,omitempty"`
+	Type      string             `json:"type"`
+	ProductID primitive.ObjectID `json:"product_id"`
+	Vendor    string             `json:"vendor,omitempty"`
+	Product   *Product           `json:"product,omitempty"`
+	At        time.Time          `json:"at"`
+
+	// deleted is the product a delete event is about, it hides the event from who could not see the product
+	deleted *Product
+}
+
+// ChangeStreamer is the part of a collection that opens change streams
+type ChangeStreamer interface {
+	Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error)
+}
+
+// Broker fans product events out to subscribers and keeps the latest ones for Last-Event-ID resume.
+// Events come from a Mongo change stream when WatchChanges succeeds, otherwise from the handler functions.
+type Broker struct {
+	// History is how many past events are kept for resuming without a change stream
+	History int
+
+	mu           sync.Mutex
+	seq          uint64
+	history      []Event
+	subscribers  map[chan Event]struct{}
+	changeStream bool
+	// db and the collection names are kept to resume change streams after a client's token
+	db           ChangeStreamer
+	productsColl string
+	outboxColl   string
+}
+
+// Publish assigns the next id to event and delivers it, a nil Broker drops it
+func (b *Broker) Publish(event Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event.ID = b.seq
+	if event.At.IsZero() {
+		event.At = time.Now().UTC()
+	}
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.History {
+		b.history = b.history[len(b.history)-b.History:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// the subscriber can spot the gap from the ids
+		}
+	}
+}
+
+// publishChange is called by the handler functions, it is a no-op while a change stream feeds the broker
+func (b *Broker) publishChange(eventType string, product Product) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	fromStream := b.changeStream
+	b.mu.Unlock()
+	if fromStream {
+		return
+	}
+
+	event := Event{Type: eventType, ProductID: product.ID, Vendor: product.Vendor}
+	if eventType == EventDeleted {
+		event.deleted = &product
+	} else {
+		event.Product = &product
+	}
+	b.Publish(event)
+}
+
+// Subscribe returns the kept events after lastID and a channel for the next ones,
+// a nil Broker has no events and its channel never delivers
+func (b *Broker) Subscribe(lastID uint64) ([]Event, <-chan Event, func()) {
+	if b == nil {
+		return nil, nil, func() {}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var backlog []Event
+	for _, event := range b.history {
+		if event.ID > lastID {
+			backlog = append(backlog, event)
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan Event]struct{})
+	}
+	b.subscribers[ch] = struct{}{}
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, ch)
+	}
+	return backlog, ch, unsubscribe
+}
+
+// change is the part of a change stream document the broker reads
+type change struct {
+	Token struct {
+		Data string `bson:"_data"`
+	} `bson:"_id"`
+	OperationType string `bson:"operationType"`
+	NS            struct {
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument bson.Raw `bson:"fullDocument"`
+}
+
+// deletion is the part of an outbox event a delete is read from
+type deletion struct {
+	AggregateID string `bson:"aggregate_id"`
+	Payload     []byte `bson:"payload"`
+}
+
+// watch opens a change stream on the product writes and on the delete events of the outbox,
+// change stream deletes only carry the _id so the vendor and the visibility come from the outbox payload
+func watch(ctx context.Context, db ChangeStreamer, productsColl, outboxColl string, resumeToken string) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"$or": bson.A{
+		bson.M{"ns.coll": productsColl, "operationType": bson.M{"$in": bson.A{"insert", "update", "replace"}}},
+		bson.M{"ns.coll": outboxColl, "operationType": "insert", "fullDocument.type": EventDeleted},
+	}}}}}
+
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if resumeToken != "" {
+		opts.SetResumeAfter(bson.M{"_data": resumeToken})
+	}
+	return db.Watch(ctx, pipeline, opts)
+}
+
+// eventOf turns a change into an Event, false for changes that are not product events
+func eventOf(c change, productsColl string) (Event, bool) {
+	event := Event{Token: c.Token.Data}
+
+	if c.NS.Coll != productsColl {
+		var d deletion
+		if err := bson.Unmarshal(c.FullDocument, &d); err != nil {
+			log.Errorf("Unable to decode the outbox event: %v", err)
+			return event, false
+		}
+		var product Product
+		if err := json.Unmarshal(d.Payload, &product); err != nil {
+			log.Errorf("Unable to decode the deleted product: %v", err)
+			return event, false
+		}
+		id, err := primitive.ObjectIDFromHex(d.AggregateID)
+		if err != nil {
+			log.Errorf("Unable to decode the deleted product id: %v", err)
+			return event, false
+		}
+
+		product.ID = id
+		event.Type, event.ProductID, event.Vendor, event.deleted = EventDeleted, id, product.Vendor, &product
+		return event, true
+	}
+
+	switch c.OperationType {
+	case "insert":
+		event.Type = EventCreated
+	case "update", "replace":
+		event.Type = EventUpdated
+	default:
+		return event, false
+	}
+	event.ProductID = c.DocumentKey.ID
+
+	if len(c.FullDocument) > 0 {
+		var product Product
+		if err := bson.Unmarshal(c.FullDocument, &product); err != nil {
+			log.Errorf("Unable to decode the changed product: %v", err)
+			return event, false
+		}
+		event.Product, event.Vendor = &product, product.Vendor
+	}
+	return event, true
+}
+
+// WatchChanges feeds the broker from a change stream on db, it fails straight away when the database is not a replica set
+func (b *Broker) WatchChanges(ctx context.Context, db ChangeStreamer, productsColl, outboxColl string) error {
+	stream, err := watch(ctx, db, productsColl, outboxColl, "")
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.changeStream = true
+	b.db, b.productsColl, b.outboxColl = db, productsColl, outboxColl
+	b.mu.Unlock()
+
+	go func() {
+		defer stream.Close(ctx)
+
+		for stream.Next(ctx) {
+			var c change
+			if err := stream.Decode(&c); err != nil {
+				log.Errorf("Unable to decode change event: %v", err)
+				continue
+			}
+			if event, ok := eventOf(c, productsColl); ok {
+				b.Publish(event)
+			}
+		}
+
+		log.Errorf("Change stream closed, falling back to in-process events: %v", stream.Err())
+		b.mu.Lock()
+		b.changeStream = false
+		b.mu.Unlock()
+	}()
+
+	return nil
+}
+
+// Resume streams the events after resumeToken from a change stream of its own until ctx is done,
+// so a client picks up where it left off on any instance and across restarts
+func (b *Broker) Resume(ctx context.Context, resumeToken string) (<-chan Event, error) {
+	if b == nil {
+		return nil, errNoChangeStream
+	}
+
+	b.mu.Lock()
+	db, productsColl, outboxColl, fromStream := b.db, b.productsColl, b.outboxColl, b.changeStream
+	b.mu.Unlock()
+	if !fromStream {
+		return nil, errNoChangeStream
+	}
+
+	stream, err := watch(ctx, db, productsColl, outboxColl, resumeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event, subscriberBuffer)
+	go func() {
+		defer close(events)
+		defer stream.Close(context.Background())
+
+		for stream.Next(ctx) {
+			var c change
+			if err := stream.Decode(&c); err != nil {
+				log.Errorf("Unable to decode change event: %v", err)
+				continue
+			}
+			event, ok := eventOf(c, productsColl)
+			if !ok {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// For returns the event as the caller may see it, false when the product is hidden from them
+func (event Event) For(claims auth.Claims) (Event, bool) {
+	if event.Product == nil {
+		// a delete tells that the product existed, so it is hidden like the product was
+		return event, event.deleted == nil || isVisible(*event.deleted, claims)
+	}
+	if !isVisible(*event.Product, claims) {
+		return event, false
+	}
+
+	product := *event.Product
+	shapeProduct(&product, claims)
+	event.Product = &product
+	return event, true
+}
+
+// matchesEvent applies the stream's vendor and product_id filters
+func matchesEvent(event Event, vendor, productID string) bool {
+	if vendor != "" && event.Vendor != vendor {
+		return false
+	}
+	if productID != "" && event.ProductID.Hex() != productID {
+		return false
+	}
+	return true
+}
+
+func writeEvent(c echo.Context, event Event, claims auth.Claims) error {
+	event, ok := event.For(claims)
+	if !ok {
+		return nil
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	id := event.Token
+	if id == "" {
+		id = strconv.FormatUint(event.ID, 10)
+	}
+	if _, err := fmt.Fprintf(c.Response(), "id: %s\nevent: %s\ndata: %s\n\n", id, event.Type, data); err != nil {
+		return err
+	}
+	c.Response().Flush()
+	return nil
+}
+
+// subscribe picks where a stream starts from Last-Event-ID: a resume token resumes the change stream,
+// a number resumes from the in-process history and an unusable id starts from now
+func (h *ProductsHandler) subscribe(ctx context.Context, lastEventID string) ([]Event, <-chan Event, func()) {
+	if lastEventID == "" {
+		return h.Events.Subscribe(0)
+	}
+	if lastID, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+		return h.Events.Subscribe(lastID)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	events, err := h.Events.Resume(ctx, lastEventID)
+	if err != nil {
+		cancel()
+		log.Warnf("Unable to resume after %s, streaming from now: %v", lastEventID, err)
+		return h.Events.Subscribe(^uint64(0))
+	}
+	return nil, events, cancel
+}
+
+// StreamEvents streams product changes as Server-Sent Events
+func (h *ProductsHandler) StreamEvents(c echo.Context) error {
+	claims := auth.FromContext(c)
+	vendor, productID := c.QueryParam("vendor"), c.QueryParam("product_id")
+	backlog, events, unsubscribe := h.subscribe(c.Request().Context(), c.Request().Header.Get("Last-Event-ID"))
+	defer unsubscribe()
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().Header().Set("Connection", "keep-alive")
+	c.Response().WriteHeader(http.StatusOK)
+	c.Response().Flush()
+
+	for _, event := range backlog {
+		if !matchesEvent(event, vendor, productID) {
+			continue
+		}
+		if err := writeEvent(c, event, claims); err != nil {
+			return nil
+		}
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Response(), ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			c.Response().Flush()
+		case event, ok := <-events:
+			if !ok {
+				// the resumed change stream ended, the client reconnects with its last id
+				return nil
+			}
+			if !matchesEvent(event, vendor, productID) {
+				continue
+			}
+			if err := writeEvent(c, event, claims); err != nil {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package products
+
+import (
+	"context"
+	"fmt"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/hypermedia"
+	"learning-golang-restful-api/negotiation"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// FacetCount describes how many products share a value
+type FacetCount struct {
+	Value interface{} `json:"value" bson:"_id"`
+	Count int         `json:"count" bson:"count"`
+}
+
+// Facets describes the product counts a storefront needs to render its filters
+type Facets struct {
+	Vendors     []FacetCount `json:"vendors" bson:"vendors"`
+	Currencies  []FacetCount `json:"currencies" bson:"currencies"`
+	Accessories []FacetCount `json:"accessories" bson:"accessories"`
+	IsEssential []FacetCount `json:"is_essential" bson:"is_essential"`
+	Prices      []FacetCount `json:"prices" bson:"prices"`
+}
+
+// FacetedList is a listing answered with the facets of its filters, when asked with ?facets=true
+type FacetedList struct {
+	Products interface{} `json:"products"`
+	Facets   *Facets     `json:"facets"`
+}
+
+// otherPriceBucket collects prices outside the bucket boundaries
+const otherPriceBucket = "other"
+
+// parsePriceBuckets reads comma separated, strictly ascending boundaries
+func parsePriceBuckets(value string) ([]int, error) {
+	var boundaries []int
+	for _, part := range strings.Split(value, ",") {
+		boundary, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		boundaries = append(boundaries, boundary)
+	}
+
+	if len(boundaries) < 2 {
+		return nil, fmt.Errorf("at least two boundaries are required")
+	}
+	if !sort.SliceIsSorted(boundaries, func(i, j int) bool { return boundaries[i] <= boundaries[j] }) {
+		return nil, fmt.Errorf("boundaries must be ascending")
+	}
+	for i := 1; i < len(boundaries); i++ {
+		if boundaries[i] == boundaries[i-1] {
+			return nil, fmt.Errorf("boundaries must be unique")
+		}
+	}
+
+	return boundaries, nil
+}
+
+// labelPriceBuckets replaces each bucket's lower boundary with a "from-to" label
+func labelPriceBuckets(prices []FacetCount, boundaries []int) {
+	for i, price := range prices {
+		var lower int
+		switch n := price.Value.(type) {
+		case int32:
+			lower = int(n)
+		case int64:
+			lower = int(n)
+		default:
+			continue
+		}
+
+		for j := 0; j < len(boundaries)-1; j++ {
+			if boundaries[j] == lower {
+				prices[i].Value = fmt.Sprintf("%d-%d", boundaries[j], boundaries[j+1])
+				break
+			}
+		}
+	}
+}
+
+func findFacets(ctx context.Context, qs url.Values, claims auth.Claims, boundaries []int, coll CollectionAPI) (*Facets, *domainerr.Error) {
+	facets := &Facets{}
+
+	if qs.Get("price_buckets") != "" {
+		parsed, err := parsePriceBuckets(qs.Get("price_buckets"))
+		if err != nil {
+			log.Errorf("Unable to parse price buckets: %v", err)
+			return facets, domainerr.New(domainerr.InvalidArgument, "Unable to parse price_buckets: "+err.Error())
+		}
+		boundaries = parsed
+	}
+
+	filter, domainErr := productsFilter(qs, claims)
+	if domainErr != nil {
+		return facets, domainErr
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: bson.M{
+			"vendors":      bson.A{bson.M{"$sortByCount": "$vendor"}},
+			"currencies":   bson.A{bson.M{"$sortByCount": "$currency"}},
+			"accessories":  bson.A{bson.M{"$unwind": "$accessories"}, bson.M{"$sortByCount": "$accessories"}},
+			"is_essential": bson.A{bson.M{"$sortByCount": "$is_essential"}},
+			"prices": bson.A{bson.M{"$bucket": bson.M{
+				"groupBy":    "$price",
+				"boundaries": boundaries,
+				"default":    otherPriceBucket,
+				"output":     bson.M{"count": bson.M{"$sum": 1}},
+			}}},
+		}}},
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Errorf("Unable to aggregate facets: %v", err)
+		return facets, domainerr.New(domainerr.Internal, "Unable to aggregate facets")
+	}
+
+	var results []Facets
+	if err := cursor.All(ctx, &results); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return facets, domainerr.New(domainerr.Internal, "Unable to read the cursor")
+	}
+
+	// $facet always yields a single document
+	if len(results) > 0 {
+		facets = &results[0]
+	}
+	labelPriceBuckets(facets.Prices, boundaries)
+
+	return facets, nil
+}
+
+func (h *ProductsHandler) GetFacets(c echo.Context) error {
+	facets, err := findFacets(context.Background(), c.QueryParams(), auth.FromContext(c), h.PriceBuckets, h.Coll)
+	if err != nil {
+		return err
+	}
+
+	return negotiation.Write(c, http.StatusOK, facets)
+}
+
+// withFacets adds the facets of the listing's filters when the client asks with ?facets=true,
+// hypermedia documents get them in their meta and plain bodies become a FacetedList
+func (h *ProductsHandler) withFacets(c echo.Context, doc *hypermedia.Document, list interface{}) (interface{}, error) {
+	if c.QueryParam("facets") != "true" {
+		return list, nil
+	}
+
+	facets, err := findFacets(context.Background(), c.QueryParams(), auth.FromContext(c), h.PriceBuckets, h.Coll)
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.Meta == nil {
+		doc.Meta = map[string]interface{}{}
+	}
+	doc.Meta["facets"] = facets
+	return FacetedList{Products: list, Facets: facets}, nil
+}
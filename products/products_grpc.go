@@ -0,0 +1,228 @@
+package products
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/productspb"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ProductServer serves ProductService over gRPC with the same logic as the REST handlers
+type ProductServer struct {
+	productspb.UnimplementedProductServiceServer
+	Handler *ProductsHandler
+}
+
+// grpcCodes maps the kind of a domain error to a gRPC code
+var grpcCodes = map[domainerr.Kind]codes.Code{
+	domainerr.Internal:        codes.Internal,
+	domainerr.InvalidArgument: codes.InvalidArgument,
+	domainerr.NotFound:        codes.NotFound,
+	domainerr.Conflict:        codes.AlreadyExists,
+	domainerr.Unauthorized:    codes.Unauthenticated,
+	domainerr.Forbidden:       codes.PermissionDenied,
+	domainerr.Unavailable:     codes.Unavailable,
+}
+
+// grpcError turns a domain error into a gRPC status error, kinds without a code are internal errors
+// as a zero code would report success
+func grpcError(err *domainerr.Error) error {
+	code, ok := grpcCodes[err.Kind]
+	if !ok {
+		code = codes.Internal
+	}
+	return status.Error(code, err.Message)
+}
+
+func toTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// toProto converts a product for the wire
+func toProto(product Product) *productspb.Product {
+	pb := &productspb.Product{
+		Name:          product.Name,
+		Price:         int32(product.Price),
+		Currency:      product.Currency,
+		Discount:      int32(product.Discount),
+		Vendor:        product.Vendor,
+		Accessories:   product.Accessories,
+		IsEssential:   product.IsEssential,
+		PurchaseLimit: int32(product.PurchaseLimit),
+		CostPrice:     int32(product.CostPrice),
+		InternalNotes: product.InternalNotes,
+		CreatedBy:     product.CreatedBy,
+		UpdatedBy:     product.UpdatedBy,
+		Status:        product.Status,
+		CreatedAt:     toTimestamp(product.CreatedAt),
+		UpdatedAt:     toTimestamp(product.UpdatedAt),
+	}
+	if !product.ID.IsZero() {
+		pb.Id = product.ID.Hex()
+	}
+	if product.PublishAt != nil {
+		pb.PublishAt = timestamppb.New(*product.PublishAt)
+	}
+	for _, rule := range product.DiscountRules {
+		pb.DiscountRules = append(pb.DiscountRules, &productspb.DiscountRule{MinQuantity: int32(rule.MinQuantity), Discount: int32(rule.Discount)})
+	}
+	for _, change := range product.StatusHistory {
+		pb.StatusHistory = append(pb.StatusHistory, &productspb.StatusChange{From: change.From, To: change.To, By: change.By, At: toTimestamp(change.At)})
+	}
+	return pb
+}
+
+// fromProto converts a product from the wire, the fields the server keeps are left out
+func fromProto(pb *productspb.Product) Product {
+	product := Product{
+		Name:          pb.GetName(),
+		Price:         int(pb.GetPrice()),
+		Currency:      pb.GetCurrency(),
+		Discount:      int(pb.GetDiscount()),
+		Vendor:        pb.GetVendor(),
+		Accessories:   pb.GetAccessories(),
+		IsEssential:   pb.GetIsEssential(),
+		PurchaseLimit: int(pb.GetPurchaseLimit()),
+		CostPrice:     int(pb.GetCostPrice()),
+		InternalNotes: pb.GetInternalNotes(),
+		Status:        pb.GetStatus(),
+	}
+	if pb.GetPublishAt() != nil {
+		publishAt := pb.GetPublishAt().AsTime()
+		product.PublishAt = &publishAt
+	}
+	for _, rule := range pb.GetDiscountRules() {
+		product.DiscountRules = append(product.DiscountRules, DiscountRule{MinQuantity: int(rule.GetMinQuantity()), Discount: int(rule.GetDiscount())})
+	}
+	return product
+}
+
+// updateBody builds the JSON body updateProduct decodes, with the fields in mask or, without one, the fields that are set
+func updateBody(product Product, mask []string) ([]byte, *domainerr.Error) {
+	fields := map[string]interface{}{}
+	set := map[string]bool{}
+
+	v := reflect.ValueOf(product)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || name == "_id" {
+			continue
+		}
+		fields[name] = v.Field(i).Interface()
+		set[name] = !v.Field(i).IsZero()
+	}
+
+	body := map[string]interface{}{}
+	if len(mask) == 0 {
+		for name, value := range fields {
+			if set[name] {
+				body[name] = value
+			}
+		}
+	}
+	for _, path := range mask {
+		value, ok := fields[path]
+		if !ok {
+			return nil, domainerr.New(domainerr.InvalidArgument, "Unknown field in update_mask: "+path)
+		}
+		body[path] = value
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, domainerr.New(domainerr.Internal, "Unable to encode the update")
+	}
+	return b, nil
+}
+
+func (s *ProductServer) Create(ctx context.Context, req *productspb.CreateRequest) (*productspb.CreateResponse, error) {
+	var products []Product
+	for _, pb := range req.GetProducts() {
+		products = append(products, fromProto(pb))
+	}
+
+	ids, err := s.Handler.Create(ctx, products, auth.FromIncoming(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	res := &productspb.CreateResponse{}
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			res.Ids = append(res.Ids, oid.Hex())
+		}
+	}
+	return res, nil
+}
+
+func (s *ProductServer) Get(ctx context.Context, req *productspb.GetRequest) (*productspb.Product, error) {
+	product, err := s.Handler.Get(ctx, req.GetId(), auth.FromIncoming(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProto(*product), nil
+}
+
+func (s *ProductServer) List(req *productspb.ListRequest, stream productspb.ProductService_ListServer) error {
+	qs := url.Values{}
+	for k, v := range req.GetFilter() {
+		qs.Set(k, v)
+	}
+	if req.GetLimit() > 0 {
+		qs.Set("limit", strconv.FormatInt(req.GetLimit(), 10))
+	}
+	if req.GetOffset() > 0 {
+		qs.Set("offset", strconv.FormatInt(req.GetOffset(), 10))
+	}
+
+	ctx := stream.Context()
+	products, err := s.Handler.Find(ctx, qs, auth.FromIncoming(ctx))
+	if err != nil {
+		return grpcError(err)
+	}
+
+	for _, product := range products {
+		if err := stream.Send(toProto(product)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ProductServer) Update(ctx context.Context, req *productspb.UpdateRequest) (*productspb.Product, error) {
+	body, domainErr := updateBody(fromProto(req.GetProduct()), req.GetUpdateMask().GetPaths())
+	if domainErr != nil {
+		return nil, grpcError(domainErr)
+	}
+
+	product, domainErr := s.Handler.Update(ctx, req.GetId(), ioutil.NopCloser(bytes.NewReader(body)), auth.FromIncoming(ctx))
+	if domainErr != nil {
+		return nil, grpcError(domainErr)
+	}
+	return toProto(*product), nil
+}
+
+func (s *ProductServer) Delete(ctx context.Context, req *productspb.DeleteRequest) (*productspb.DeleteResponse, error) {
+	deleted, err := s.Handler.Delete(ctx, req.GetId(), auth.FromIncoming(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &productspb.DeleteResponse{Deleted: int64(deleted)}, nil
+}
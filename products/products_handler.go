@@ -3,35 +3,107 @@ package products
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/hypermedia"
+	"learning-golang-restful-api/negotiation"
 	"net/http"
 	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/gommon/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// reservedParams are query params that control a request rather than filter products
+var reservedParams = map[string]bool{
+	"price_buckets": true,
+	"mine":          true,
+	"updated_since": true,
+	"limit":         true,
+	"offset":        true,
+	"include":       true,
+	"facets":        true,
+}
+
 type ProductsHandler struct {
-	Coll CollectionAPI
+	Coll         CollectionAPI
+	PricesColl   CollectionAPI
+	PriceWatcher PriceWatcher
+	// PriceBuckets are the default boundaries of the price facet
+	PriceBuckets []int
+	// CompareMax caps how many products can be compared at once
+	CompareMax int
+	// CompareCurrency is the currency compared prices are normalized to
+	CompareCurrency string
+	// ExchangeRates are units of each currency per common unit
+	ExchangeRates map[string]float64
+	// Related is the in-memory index behind related products
+	Related        *RelatedIndex
+	RelatedWeights RelatedWeights
+	// Events streams product changes to subscribers
+	Events *Broker
+	// Outbox records domain events with the writes, nil skips it
+	Outbox Outbox
 }
 
-func createProducts(ctx context.Context, products []Product, coll CollectionAPI) ([]interface{}, *echo.HTTPError) {
+func createProducts(ctx context.Context, products []Product, claims auth.Claims, events *Broker, box Outbox, pricesColl CollectionAPI, coll CollectionAPI) ([]interface{}, *domainerr.Error) {
 	var insertedIds []interface{}
 
-	for _, product := range products {
+	// Mongo keeps milliseconds, truncate so responses match what is stored
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i := range products {
+		product := &products[i]
 		product.ID = primitive.NewObjectID()
+		product.CreatedBy = claims.UserID
+		product.UpdatedBy = claims.UserID
+		product.CreatedAt = now
+		product.UpdatedAt = now
 
-		res, err := coll.InsertOne(ctx, product)
-		if err != nil {
-			log.Errorf("Unable to insert: %v", err)
-			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Unable to insert")
+		if err := resolveStatus(product, "", now); err != nil {
+			return nil, err
 		}
+		product.StatusHistory = []StatusChange{{To: product.Status, By: claims.UserID, At: now}}
+	}
 
-		insertedIds = append(insertedIds, res.InsertedID)
+	// the products, their first price points and their events are stored together or not at all
+	err := inTransaction(ctx, box, func(ctx context.Context) *domainerr.Error {
+		insertedIds = nil
+		for _, product := range products {
+			res, err := coll.InsertOne(ctx, product)
+			if err != nil {
+				log.Errorf("Unable to insert: %v", err)
+				return domainerr.New(domainerr.Internal, "Unable to insert")
+			}
+
+			// the first point of the price history
+			if err := recordPrice(ctx, product, now, pricesColl); err != nil {
+				return err
+			}
+			if err := recordEvent(ctx, box, EventCreated, product); err != nil {
+				return err
+			}
+
+			insertedIds = append(insertedIds, res.InsertedID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	for _, product := range products {
+		events.publishChange(EventCreated, product)
+	}
 	return insertedIds, nil
 }
 
@@ -39,31 +111,62 @@ func (h *ProductsHandler) CreateProducts(c echo.Context) error {
 	c.Echo().Validator = &ProductValidator{validator: v}
 
 	var products []Product
-	if err := c.Bind(&products); err != nil {
+	if err := negotiation.Bind(c, &products); err != nil {
 		log.Errorf("Unable to bind: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to bind data")
-	}
-
-	for _, product := range products {
-		if err := c.Validate(product); err != nil {
-			log.Errorf("Unable to validate the product %+v %v", product, err)
-			return echo.NewHTTPError(http.StatusBadRequest, "Unable to validate the product")
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
 		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to bind data")
 	}
 
-	ids, err := createProducts(context.Background(), products, h.Coll)
+	claims := auth.FromContext(c)
+	ids, err := h.Create(context.Background(), products, claims)
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusCreated, ids)
+	// hypermedia clients get the created resources, plain ones keep getting the ids
+	shapeProducts(products, claims)
+	return hypermedia.Render(c, http.StatusCreated, productsDocument(c, products, true, v1Attributes), ids)
 }
 
-func findProducts(ctx context.Context, qs url.Values, collection CollectionAPI) ([]Product, *echo.HTTPError) {
-	var products []Product
-	filter := make(map[string]interface{})
+// filterKinds are the kinds of the Product fields by JSON name, query params are matched as the stored type
+var filterKinds = func() map[string]reflect.Kind {
+	kinds := map[string]reflect.Kind{}
+	t := reflect.TypeOf(Product{})
+	for i := 0; i < t.NumField(); i++ {
+		kinds[strings.Split(t.Field(i).Tag.Get("json"), ",")[0]] = t.Field(i).Type.Kind()
+	}
+	return kinds
+}()
+
+// filterValue converts a query param to the type of the field it filters, e.g. is_essential=true to a bool
+func filterValue(name, value string) (interface{}, error) {
+	switch filterKinds[name] {
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int:
+		return strconv.Atoi(value)
+	}
+	return value, nil
+}
+
+// productsFilter turns query params into a Mongo filter, skipping the reserved params
+func productsFilter(qs url.Values, claims auth.Claims) (bson.M, *domainerr.Error) {
+	filter := bson.M{}
 	for k, v := range qs {
-		filter[k] = v[0]
+		if reservedParams[k] {
+			continue
+		}
+		if err := checkReadable(k, claims); err != nil {
+			return filter, err
+		}
+		value, err := filterValue(k, v[0])
+		if err != nil {
+			log.Errorf("Unable to parse %s: %v", k, err)
+			return filter, domainerr.New(domainerr.InvalidArgument, "Unable to parse "+k)
+		}
+		filter[k] = value
 	}
 
 	if filter["_id"] != nil {
@@ -71,134 +174,333 @@ func findProducts(ctx context.Context, qs url.Values, collection CollectionAPI)
 		_id, err := primitive.ObjectIDFromHex(filter["_id"].(string))
 		if err != nil {
 			log.Errorf("Unable to convert id to _id: %v", err)
-			return products, echo.NewHTTPError(http.StatusInternalServerError, "Unable to convert id to _id")
+			return filter, domainerr.New(domainerr.InvalidArgument, "Unable to convert id to _id")
 		}
 		filter["_id"] = _id
 	}
 
-	cursor, err := collection.Find(ctx, bson.M(filter))
+	if qs.Get("mine") == "true" {
+		filter["created_by"] = claims.UserID
+	}
+
+	if qs.Get("updated_since") != "" {
+		since, err := parseTimeParam(qs.Get("updated_since"))
+		if err != nil {
+			log.Errorf("Unable to parse updated_since: %v", err)
+			return filter, domainerr.New(domainerr.InvalidArgument, "Unable to parse updated_since")
+		}
+		filter["updated_at"] = bson.M{"$gte": since}
+	}
+
+	visibleFilter(filter, claims)
+
+	return filter, nil
+}
+
+// pageParam reads a non negative limit or offset, zero when absent
+func pageParam(qs url.Values, name string) (int64, *domainerr.Error) {
+	if qs.Get(name) == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(qs.Get(name), 10, 64)
+	if err != nil || n < 0 {
+		log.Errorf("Unable to parse %s: %v", name, qs.Get(name))
+		return 0, domainerr.New(domainerr.InvalidArgument, "Unable to parse "+name)
+	}
+	return n, nil
+}
+
+func findProducts(ctx context.Context, qs url.Values, claims auth.Claims, collection CollectionAPI) ([]Product, *domainerr.Error) {
+	var products []Product
+	filter, domainErr := productsFilter(qs, claims)
+	if domainErr != nil {
+		return products, domainErr
+	}
+
+	// delta sync reads the changes oldest first
+	opts := options.Find()
+	if qs.Get("updated_since") != "" {
+		opts.SetSort(bson.M{"updated_at": 1})
+	}
+
+	// pages need a stable order, ids grow with insertion
+	if qs.Get("limit") != "" || qs.Get("offset") != "" {
+		limit, err := pageParam(qs, "limit")
+		if err != nil {
+			return products, err
+		}
+		offset, err := pageParam(qs, "offset")
+		if err != nil {
+			return products, err
+		}
+		opts.SetLimit(limit).SetSkip(offset)
+		if qs.Get("updated_since") == "" {
+			opts.SetSort(bson.M{"_id": 1})
+		}
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
 	if err != nil {
 		log.Errorf("Unable to find products : %v", err)
-		return products, echo.NewHTTPError(http.StatusNotFound, "Unable to find products")
+		return products, domainerr.New(domainerr.Internal, "Unable to find products")
 	}
 
 	err = cursor.All(ctx, &products)
 	if err != nil {
 		log.Errorf("Unable to read the cursor : %v", err)
-		return products, echo.NewHTTPError(http.StatusInternalServerError, "Unable to read the cursor")
+		return products, domainerr.New(domainerr.Internal, "Unable to read the cursor")
 	}
 
+	shapeProducts(products, claims)
 	return products, nil
 }
 
 func (h *ProductsHandler) GetProducts(c echo.Context) error {
-	products, err := findProducts(context.Background(), c.QueryParams(), h.Coll)
+	products, err := findProducts(context.Background(), c.QueryParams(), auth.FromContext(c), h.Coll)
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, &products)
-}
+	doc := productsDocument(c, products, true, v1Attributes)
+	body, facetsErr := h.withFacets(c, &doc, &products)
+	if facetsErr != nil {
+		return facetsErr
+	}
 
-func findProduct(ctx context.Context, id string, coll CollectionAPI) (*Product, *echo.HTTPError) {
-	var product Product
+	return hypermedia.Render(c, http.StatusOK, doc, body)
+}
 
+// objectID parses the id of a product, a malformed one is the caller's mistake
+func objectID(id string) (primitive.ObjectID, *domainerr.Error) {
 	_id, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Errorf("Unable to convert id to _id: %v", err)
-		return &product, echo.NewHTTPError(http.StatusInternalServerError, "Unable to convert id to _id")
+		return _id, domainerr.New(domainerr.InvalidArgument, "Unable to convert id to _id").Wrap(err)
+	}
+	return _id, nil
+}
+
+// findError tells a missing product apart from a failed lookup
+func findError(err error) *domainerr.Error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return domainerr.New(domainerr.NotFound, "Product does not exists").Wrap(err)
+	}
+	log.Errorf("Unable to decode FindOne res: %v", err)
+	return domainerr.New(domainerr.Internal, "Unable to decode FindOne res").Wrap(err)
+}
+
+func findProduct(ctx context.Context, id string, claims auth.Claims, coll CollectionAPI) (*Product, *domainerr.Error) {
+	var product Product
+
+	_id, err := objectID(id)
+	if err != nil {
+		return &product, err
 	}
 
 	filter := bson.M{"_id": _id}
-	res := coll.FindOne(ctx, filter)
-	if err := res.Decode(&product); err != nil {
-		log.Errorf("Unable to decode FindOne res: %v", err)
-		return &product, echo.NewHTTPError(http.StatusInternalServerError, "Unable to convert id to _id")
+	if err := coll.FindOne(ctx, filter).Decode(&product); err != nil {
+		return &product, findError(err)
+	}
+
+	if !isVisible(product, claims) {
+		return &Product{}, domainerr.New(domainerr.NotFound, "Product does not exists")
 	}
 
+	shapeProduct(&product, claims)
 	return &product, nil
 }
 
 func (h *ProductsHandler) GetProduct(c echo.Context) error {
-	products, err := findProduct(context.Background(), c.Param("id"), h.Coll)
+	products, err := findProduct(context.Background(), c.Param("id"), auth.FromContext(c), h.Coll)
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, &products)
+	if notModified(c, *products) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return hypermedia.Render(c, http.StatusOK, productsDocument(c, []Product{*products}, false, v1Attributes), &products)
 }
 
-func updateProduct(ctx context.Context, id string, body io.ReadCloser, coll CollectionAPI) (*Product, *echo.HTTPError) {
+// notModified sets Last-Modified and reports whether the client copy from If-Modified-Since is still current
+func notModified(c echo.Context, product Product) bool {
+	if product.UpdatedAt.IsZero() {
+		return false
+	}
+
+	// HTTP dates have a one second resolution
+	lastModified := product.UpdatedAt.UTC().Truncate(time.Second)
+	c.Response().Header().Set(echo.HeaderLastModified, lastModified.Format(http.TimeFormat))
+
+	since, err := http.ParseTime(c.Request().Header.Get(echo.HeaderIfModifiedSince))
+	return err == nil && !lastModified.After(since)
+}
+
+// createdAt is when product was created, products stored before the timestamps existed
+// fall back to the creation time of their ObjectID
+func createdAt(product Product) time.Time {
+	if product.CreatedAt.IsZero() {
+		return product.ID.Timestamp().UTC()
+	}
+	return product.CreatedAt
+}
+
+func updateProduct(ctx context.Context, id string, body io.ReadCloser, claims auth.Claims, watcher PriceWatcher, events *Broker, box Outbox, pricesColl CollectionAPI, coll CollectionAPI) (*Product, *domainerr.Error) {
 	var product Product
 
 	// 1. find product or return 404
-	_id, err := primitive.ObjectIDFromHex(id)
+	_id, err := objectID(id)
 	if err != nil {
-		log.Errorf("Unable to convert id to _id: %v", err)
-		return &product, echo.NewHTTPError(http.StatusInternalServerError, "Unable to convert id to _id")
+		return &product, err
 	}
 
 	filter := bson.M{"_id": _id}
-	res := coll.FindOne(ctx, filter)
-	if err := res.Decode(&product); err != nil {
-		log.Errorf("Unable to decode FindOne res: %v", err)
-		return &product, echo.NewHTTPError(http.StatusInternalServerError, "Unable to decode FindOne res")
+	if err := coll.FindOne(ctx, filter).Decode(&product); err != nil {
+		return &product, findError(err)
+	}
+	before := product
+
+	// 2. only the creator or an admin may change it, otherwise return 403
+	if err := canModify(claims, product); err != nil {
+		return &product, err
 	}
 
-	// 2. decode body to struct or return 500
+	// 3. decode body to struct or return 400
 	if err := json.NewDecoder(body).Decode(&product); err != nil {
 		log.Errorf("Unable to decode from req body to struct: %v", err)
-		return &product, echo.NewHTTPError(http.StatusInternalServerError, "Unable to decode from req body to struct")
+		return &product, domainerr.New(domainerr.InvalidArgument, "Unable to decode from req body to struct").Wrap(err)
 	}
 
-	// 3. validate decoded body or return 400
+	// 4. validate decoded body or return 400
 	if err := v.Struct(&product); err != nil {
-		log.Errorf("Unable to decode from struct: %v", err)
-		return &product, echo.NewHTTPError(http.StatusInternalServerError, "Unable to  decode from struct")
+		log.Errorf("Unable to validate the product: %v", err)
+		return &product, domainerr.New(domainerr.InvalidArgument, "Unable to validate the product").Wrap(err)
 	}
 
-	// 4. update data or return 500
-	_, err = coll.UpdateOne(ctx, filter, bson.M{"$set": product})
-	if err != nil {
-		log.Errorf("Unable to update: %v", err)
-		return &product, echo.NewHTTPError(http.StatusInternalServerError, "Unable to update")
+	// restricted fields can only be changed by the roles allowed to write them
+	if err := checkWrites(before, product, claims); err != nil {
+		return &product, err
+	}
+
+	// ownership and timestamps are kept by the server, not the body
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	product.CreatedBy = before.CreatedBy
+	product.UpdatedBy = claims.UserID
+	product.CreatedAt = createdAt(before)
+	product.UpdatedAt = now
+
+	// status changes are appended to the lifecycle history kept by the server
+	if err := resolveStatus(&product, before.Status, now); err != nil {
+		return &product, err
+	}
+	product.StatusHistory = before.StatusHistory
+	if product.Status != before.Status {
+		product.StatusHistory = append(product.StatusHistory, StatusChange{
+			From: before.Status,
+			To:   product.Status,
+			By:   claims.UserID,
+			At:   now,
+		})
+	}
+
+	// 5. update data, append to the price history and record the event together or return 500
+	domainErr := inTransaction(ctx, box, func(ctx context.Context) *domainerr.Error {
+		if _, err := coll.UpdateOne(ctx, filter, bson.M{"$set": product}); err != nil {
+			log.Errorf("Unable to update: %v", err)
+			return domainerr.New(domainerr.Internal, "Unable to update")
+		}
+
+		if priceChanged(before, product) {
+			if err := recordPrice(ctx, product, now, pricesColl); err != nil {
+				return err
+			}
+		}
+		return recordEvent(ctx, box, EventUpdated, product)
+	})
+	if domainErr != nil {
+		return &product, domainErr
 	}
 
+	// 6. publish the change once it is committed
+	events.publishChange(EventUpdated, product)
+
+	// 7. let watchers know about a price drop, the update itself already succeeded
+	if watcher != nil && product.EffectivePrice() < before.EffectivePrice() {
+		if err := watcher.PriceDropped(ctx, product, before.EffectivePrice()); err != nil {
+			log.Errorf("Unable to notify price drop: %v", err)
+		}
+	}
+
+	shapeProduct(&product, claims)
 	return &product, nil
 }
 
 func (h *ProductsHandler) UpdateProduct(c echo.Context) error {
 	c.Echo().Validator = &ProductValidator{validator: v}
 
-	product, err := updateProduct(context.Background(), c.Param("id"), c.Request().Body, h.Coll)
+	body, bodyErr := negotiation.JSONBody(c, Product{})
+	if bodyErr == echo.ErrUnsupportedMediaType {
+		return bodyErr
+	} else if bodyErr != nil {
+		log.Errorf("Unable to decode from req body: %v", bodyErr)
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to decode from req body to struct")
+	}
+
+	product, err := updateProduct(context.Background(), c.Param("id"), body, auth.FromContext(c), h.PriceWatcher, h.Events, h.Outbox, h.PricesColl, h.Coll)
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, product)
+	return hypermedia.Render(c, http.StatusOK, productsDocument(c, []Product{*product}, false, v1Attributes), product)
 }
 
-func deleteProduct(ctx context.Context, id string, coll CollectionAPI) (int, *echo.HTTPError) {
-	_id, err := primitive.ObjectIDFromHex(id)
+func deleteProduct(ctx context.Context, id string, claims auth.Claims, events *Broker, box Outbox, coll CollectionAPI) (int, *domainerr.Error) {
+	var product Product
+
+	_id, err := objectID(id)
 	if err != nil {
-		log.Errorf("Unable to convert id to _id: %v", err)
-		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Unable to convert id to _id")
+		return 0, err
 	}
 
-	res, err := coll.DeleteOne(ctx, bson.M{"_id": _id})
-	if err != nil {
-		log.Errorf("Unable to delete data: %v", err)
-		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Unable to delete data")
+	if err := coll.FindOne(ctx, bson.M{"_id": _id}).Decode(&product); err != nil {
+		return 0, findError(err)
 	}
 
-	return int(res.DeletedCount), nil
+	if err := canModify(claims, product); err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	domainErr := inTransaction(ctx, box, func(ctx context.Context) *domainerr.Error {
+		res, err := coll.DeleteOne(ctx, bson.M{"_id": _id})
+		if err != nil {
+			log.Errorf("Unable to delete data: %v", err)
+			return domainerr.New(domainerr.Internal, "Unable to delete data")
+		}
+
+		deleted = res.DeletedCount
+		if deleted == 0 {
+			return nil
+		}
+		return recordEvent(ctx, box, EventDeleted, product)
+	})
+	if domainErr != nil {
+		return 0, domainErr
+	}
+
+	if deleted > 0 {
+		events.publishChange(EventDeleted, product)
+	}
+	return int(deleted), nil
 }
 
 func (h *ProductsHandler) DeleteProduct(c echo.Context) error {
-	del, err := deleteProduct(context.Background(), c.Param("id"), h.Coll)
+	del, err := deleteProduct(context.Background(), c.Param("id"), auth.FromContext(c), h.Events, h.Outbox, h.Coll)
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, del)
+	return hypermedia.Render(c, http.StatusOK, hypermedia.Document{Meta: map[string]interface{}{"deleted": del}}, del)
 }
@@ -0,0 +1,131 @@
+package products
+
+import (
+	"learning-golang-restful-api/hypermedia"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// apiPrefix is the version prefix of the matched route, links stay within the version the client uses
+func apiPrefix(c echo.Context) string {
+	if i := strings.Index(c.Path(), "/products"); i > 0 {
+		return c.Path()[:i]
+	}
+	return ""
+}
+
+// includes reads the relations to expand from ?include=vendor,accessories
+func includes(c echo.Context) map[string]bool {
+	include := map[string]bool{}
+	for _, name := range strings.Split(c.QueryParam("include"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			include[name] = true
+		}
+	}
+	return include
+}
+
+// productResource links a product to its vendor and accessories, attributes is the DTO of the API version,
+// the related resources come back as well when include asks for them
+func productResource(prefix string, product Product, attributes interface{}, include map[string]bool) (hypermedia.Resource, []hypermedia.Resource) {
+	var included []hypermedia.Resource
+
+	vendor := hypermedia.Resource{
+		Type:       "vendors",
+		ID:         product.Vendor,
+		Attributes: map[string]string{"name": product.Vendor},
+		Links:      hypermedia.Links{"related": prefix + "/products?vendor=" + url.QueryEscape(product.Vendor)},
+	}
+	if include["vendor"] {
+		included = append(included, vendor)
+	}
+
+	accessories := make([]hypermedia.Resource, 0, len(product.Accessories))
+	for _, name := range product.Accessories {
+		accessory := hypermedia.Resource{
+			Type:       "accessories",
+			ID:         name,
+			Attributes: map[string]string{"name": name},
+			Links:      hypermedia.Links{"related": prefix + "/products?accessories=" + url.QueryEscape(name)},
+		}
+		accessories = append(accessories, accessory)
+		if include["accessories"] {
+			included = append(included, accessory)
+		}
+	}
+
+	resource := hypermedia.Resource{
+		Type:       "products",
+		ID:         product.ID.Hex(),
+		Attributes: attributes,
+		Links:      hypermedia.Links{"self": prefix + "/products/" + product.ID.Hex()},
+		Relationships: map[string]hypermedia.Relationship{
+			"vendor":      {Data: []hypermedia.Resource{vendor}, Links: vendor.Links},
+			"accessories": {Data: accessories, ToMany: true},
+		},
+	}
+	return resource, included
+}
+
+// productsDocument is the hypermedia form of products, attributes maps each one to the DTO of the API version
+func productsDocument(c echo.Context, products []Product, collection bool, attributes func(Product) interface{}) hypermedia.Document {
+	prefix, include := apiPrefix(c), includes(c)
+	doc := hypermedia.Document{Collection: collection, Name: "products"}
+
+	// a relation shared by several products is included once
+	seen := map[string]bool{}
+	for _, product := range products {
+		resource, included := productResource(prefix, product, attributes(product), include)
+		doc.Data = append(doc.Data, resource)
+		for _, related := range included {
+			if key := related.Type + "/" + related.ID; !seen[key] {
+				seen[key] = true
+				doc.Included = append(doc.Included, related)
+			}
+		}
+	}
+
+	if collection {
+		doc.Links = pageLinks(c.Request().URL, len(products))
+	}
+	return doc
+}
+
+// pageLinks links a listing to its neighbours, only when the client pages with limit
+func pageLinks(u *url.URL, count int) hypermedia.Links {
+	links := hypermedia.Links{"self": u.RequestURI()}
+
+	qs := u.Query()
+	limit, _ := strconv.Atoi(qs.Get("limit"))
+	offset, _ := strconv.Atoi(qs.Get("offset"))
+	if limit <= 0 {
+		return links
+	}
+
+	page := func(offset int) string {
+		qs.Set("offset", strconv.Itoa(offset))
+		return u.Path + "?" + qs.Encode()
+	}
+	if count == limit {
+		links["next"] = page(offset + limit)
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		links["prev"] = page(prev)
+	}
+	return links
+}
+
+func v1Attributes(product Product) interface{} {
+	return product
+}
+
+func v2Attributes(product Product) interface{} {
+	return toV2(product)
+}
@@ -15,5 +15,19 @@ type (
 		FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
 		UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
 		DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+		Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+	}
+
+	// PriceWatcher is told when a product's effective price drops
+	PriceWatcher interface {
+		PriceDropped(ctx context.Context, product Product, oldPrice int) error
+	}
+
+	// Outbox records domain events in the same transaction as the writes that cause them
+	Outbox interface {
+		Transact(ctx context.Context, fn func(ctx context.Context) error) error
+		Record(ctx context.Context, eventType string, aggregateID string, payload interface{}) error
+		// RecordInternal records an event for the service's own streams that is never relayed
+		RecordInternal(ctx context.Context, eventType string, aggregateID string, payload interface{}) error
 	}
 )
@@ -0,0 +1,160 @@
+package products
+
+import (
+	"context"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	StatusDraft     = "draft"
+	StatusScheduled = "scheduled"
+	StatusPublished = "published"
+	StatusArchived  = "archived"
+
+	// schedulerActor is recorded as the author of status changes made by the scheduler
+	schedulerActor = "scheduler"
+)
+
+// StatusChange describes one step in a product's lifecycle
+type StatusChange struct {
+	From string    `json:"from,omitempty" xml:"from,omitempty" bson:"from,omitempty"`
+	To   string    `json:"to" xml:"to" bson:"to"`
+	By   string    `json:"by,omitempty" xml:"by,omitempty" bson:"by,omitempty"`
+	At   time.Time `json:"at" xml:"at" bson:"at"`
+}
+
+// resolveStatus fills in the status a product should have and checks it is consistent with publish_at
+func resolveStatus(product *Product, current string, now time.Time) *domainerr.Error {
+	if product.Status == "" {
+		product.Status = current
+	}
+	if product.Status == "" {
+		// products without a status used to go live immediately, keep that unless publish_at is in the future
+		product.Status = StatusPublished
+		if product.PublishAt != nil && product.PublishAt.After(now) {
+			product.Status = StatusScheduled
+		}
+	}
+
+	if product.Status == StatusScheduled && product.PublishAt == nil {
+		return domainerr.New(domainerr.InvalidArgument, "A scheduled product needs publish_at")
+	}
+	return nil
+}
+
+// visibleFilter limits what non-admins see to published products, creators also see their own
+// drafts, scheduled and archived products so they can work on them before they go live
+func visibleFilter(filter bson.M, claims auth.Claims) {
+	if claims.IsAdmin {
+		return
+	}
+
+	// products created before the lifecycle existed have no status and are live
+	published := bson.M{"status": bson.M{"$in": bson.A{StatusPublished, nil}}}
+	if claims.UserID == "" {
+		// tokens without a user own nothing, not even the products without a creator
+		filter["$or"] = bson.A{published}
+		return
+	}
+	filter["$or"] = bson.A{published, bson.M{"created_by": claims.UserID}}
+}
+
+// isVisible mirrors visibleFilter for a single product
+func isVisible(product Product, claims auth.Claims) bool {
+	return claims.IsAdmin || product.IsPublished() || (claims.UserID != "" && product.CreatedBy == claims.UserID)
+}
+
+// IsPublished reports whether the product is live, products without a status predate the lifecycle and are
+func (p Product) IsPublished() bool {
+	return p.Status == "" || p.Status == StatusPublished
+}
+
+// published returns product as the scheduler publishes it at now
+func published(product Product, now time.Time) Product {
+	product.Status = StatusPublished
+	product.UpdatedBy = schedulerActor
+	product.UpdatedAt = now
+	product.StatusHistory = append(product.StatusHistory, StatusChange{
+		From: StatusScheduled,
+		To:   StatusPublished,
+		By:   schedulerActor,
+		At:   now,
+	})
+	return product
+}
+
+// publishScheduled publishes every scheduled product whose publish_at has come,
+// each one is updated and announced like any other product update
+func publishScheduled(ctx context.Context, now time.Time, events *Broker, box Outbox, coll CollectionAPI) (int, error) {
+	cursor, err := coll.Find(ctx, bson.M{"status": StatusScheduled, "publish_at": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, err
+	}
+
+	var due []Product
+	if err := cursor.All(ctx, &due); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, product := range due {
+		product = published(product, now)
+
+		updated := false
+		domainErr := inTransaction(ctx, box, func(ctx context.Context) *domainerr.Error {
+			// the status is checked again in case the product changed since it was found
+			res, err := coll.UpdateOne(ctx, bson.M{"_id": product.ID, "status": StatusScheduled}, bson.M{"$set": bson.M{
+				"status":         product.Status,
+				"updated_by":     product.UpdatedBy,
+				"updated_at":     product.UpdatedAt,
+				"status_history": product.StatusHistory,
+			}})
+			if err != nil {
+				log.Errorf("Unable to publish %s: %v", product.ID.Hex(), err)
+				return domainerr.New(domainerr.Internal, "Unable to publish")
+			}
+			updated = res.ModifiedCount > 0
+			if !updated {
+				return nil
+			}
+			return recordEvent(ctx, box, EventUpdated, product)
+		})
+		if domainErr != nil {
+			return n, domainErr
+		}
+		if !updated {
+			continue
+		}
+
+		events.publishChange(EventUpdated, product)
+		n++
+	}
+	return n, nil
+}
+
+// RunScheduler publishes scheduled products every interval until ctx is done
+func RunScheduler(ctx context.Context, interval time.Duration, events *Broker, box Outbox, coll CollectionAPI) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := publishScheduled(ctx, time.Now().UTC().Truncate(time.Millisecond), events, box, coll)
+			if err != nil {
+				log.Errorf("Unable to publish scheduled products: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Infof("Published %d scheduled products", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package products
+
+import (
+	"context"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+
+	"github.com/labstack/gommon/log"
+)
+
+// inTransaction runs fn in the outbox transaction, or straight away when there is no outbox
+func inTransaction(ctx context.Context, box Outbox, fn func(ctx context.Context) *domainerr.Error) *domainerr.Error {
+	if box == nil {
+		return fn(ctx)
+	}
+
+	var domainErr *domainerr.Error
+	err := box.Transact(ctx, func(ctx context.Context) error {
+		// a transient error runs fn again, so only the last outcome counts
+		domainErr = fn(ctx)
+		if domainErr != nil {
+			return domainErr
+		}
+		return nil
+	})
+	if domainErr != nil {
+		return domainErr
+	}
+	if err != nil {
+		log.Errorf("Unable to commit the transaction: %v", err)
+		return domainerr.New(domainerr.Internal, "Unable to commit the transaction")
+	}
+	return nil
+}
+
+// publicProduct is the payload of product events, the product as anyone may read it
+// so events never carry costs, notes or discount rules
+func publicProduct(product Product) Product {
+	shapeProduct(&product, auth.Claims{})
+	return product
+}
+
+// recordEvent adds a product event to the outbox, ctx must be the one inTransaction gave.
+// Events about products the public cannot see, such as drafts, are kept internal and never leave the service
+func recordEvent(ctx context.Context, box Outbox, eventType string, product Product) *domainerr.Error {
+	if box == nil {
+		return nil
+	}
+
+	record := box.Record
+	if !isVisible(product, auth.Claims{}) {
+		record = box.RecordInternal
+	}
+	if err := record(ctx, eventType, product.ID.Hex(), publicProduct(product)); err != nil {
+		log.Errorf("Unable to record the %s event: %v", eventType, err)
+		return domainerr.New(domainerr.Internal, "Unable to record the event")
+	}
+	return nil
+}
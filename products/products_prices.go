@@ -0,0 +1,187 @@
+package products
+
+import (
+	"context"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/negotiation"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PricePoint describes a product's pricing at the moment it changed
+type PricePoint struct {
+	ID             primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	ProductID      primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Price          int                `json:"price" bson:"price"`
+	Discount       int                `json:"discount" bson:"discount"`
+	Currency       string             `json:"currency" bson:"currency"`
+	EffectivePrice int                `json:"effective_price" bson:"effective_price"`
+	ChangedAt      time.Time          `json:"changed_at" bson:"changed_at"`
+}
+
+// PriceBucket describes the effective price of a product within one interval and currency,
+// an interval in which the currency changed has a bucket per currency
+type PriceBucket struct {
+	Period   string `json:"period" bson:"period"`
+	Min      int    `json:"min" bson:"min"`
+	Max      int    `json:"max" bson:"max"`
+	Last     int    `json:"last" bson:"last"`
+	Currency string `json:"currency" bson:"currency"`
+	Changes  int    `json:"changes" bson:"changes"`
+}
+
+// priceIntervals maps the interval query param to a $dateToString format
+var priceIntervals = map[string]string{
+	"hour":  "%Y-%m-%dT%H:00",
+	"day":   "%Y-%m-%d",
+	"week":  "%G-W%V",
+	"month": "%Y-%m",
+}
+
+func priceChanged(before, after Product) bool {
+	return before.Price != after.Price || before.Discount != after.Discount || before.Currency != after.Currency
+}
+
+func recordPrice(ctx context.Context, product Product, at time.Time, coll CollectionAPI) *domainerr.Error {
+	if coll == nil {
+		log.Warnf("No prices collection, the price of %s is not recorded", product.ID.Hex())
+		return nil
+	}
+
+	point := PricePoint{
+		ID:             primitive.NewObjectID(),
+		ProductID:      product.ID,
+		Price:          product.Price,
+		Discount:       product.Discount,
+		Currency:       product.Currency,
+		EffectivePrice: product.EffectivePrice(),
+		ChangedAt:      at,
+	}
+	if _, err := coll.InsertOne(ctx, point); err != nil {
+		log.Errorf("Unable to insert price history: %v", err)
+		return domainerr.New(domainerr.Internal, "Unable to insert price history")
+	}
+
+	return nil
+}
+
+// parseTimeParam accepts either an RFC 3339 timestamp or a plain date
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// endFilter bounds a range by the to param, a plain date includes the whole day
+func endFilter(filter bson.M, to string) error {
+	if t, err := time.Parse(time.RFC3339, to); err == nil {
+		filter["$lte"] = t
+		return nil
+	}
+
+	t, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return err
+	}
+	filter["$lt"] = t.AddDate(0, 0, 1)
+	return nil
+}
+
+// findPrices buckets the price history of a product the caller can see, hidden and unknown products are not found
+func findPrices(ctx context.Context, id string, from, to, interval string, claims auth.Claims, productsColl, coll CollectionAPI) ([]PriceBucket, *domainerr.Error) {
+	buckets := []PriceBucket{}
+
+	product, domainErr := findProduct(ctx, id, claims, productsColl)
+	if domainErr != nil {
+		return buckets, domainErr
+	}
+	for _, name := range []string{"price", "discount", "currency"} {
+		if err := checkReadable(name, claims); err != nil {
+			return buckets, err
+		}
+	}
+	_id := product.ID
+
+	if interval == "" {
+		interval = "day"
+	}
+	format, ok := priceIntervals[interval]
+	if !ok {
+		return buckets, domainerr.New(domainerr.InvalidArgument, "Interval must be one of hour, day, week or month")
+	}
+
+	changedAt := bson.M{}
+	if from != "" {
+		t, err := parseTimeParam(from)
+		if err != nil {
+			return buckets, domainerr.New(domainerr.InvalidArgument, "Unable to parse from")
+		}
+		changedAt["$gte"] = t
+	}
+	if to != "" {
+		if err := endFilter(changedAt, to); err != nil {
+			return buckets, domainerr.New(domainerr.InvalidArgument, "Unable to parse to")
+		}
+	}
+
+	match := bson.M{"product_id": _id}
+	if len(changedAt) > 0 {
+		match["changed_at"] = changedAt
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.M{"changed_at": 1}}},
+		// prices in different currencies are not comparable, so they never share a bucket
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"period":   bson.M{"$dateToString": bson.M{"format": format, "date": "$changed_at"}},
+				"currency": "$currency",
+			},
+			"min":     bson.M{"$min": "$effective_price"},
+			"max":     bson.M{"$max": "$effective_price"},
+			"last":    bson.M{"$last": "$effective_price"},
+			"changes": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":      0,
+			"period":   "$_id.period",
+			"currency": "$_id.currency",
+			"min":      1,
+			"max":      1,
+			"last":     1,
+			"changes":  1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "period", Value: 1}, {Key: "currency", Value: 1}}}},
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Errorf("Unable to aggregate prices: %v", err)
+		return buckets, domainerr.New(domainerr.Internal, "Unable to aggregate prices")
+	}
+
+	if err := cursor.All(ctx, &buckets); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return buckets, domainerr.New(domainerr.Internal, "Unable to read the cursor")
+	}
+
+	return buckets, nil
+}
+
+func (h *ProductsHandler) GetPrices(c echo.Context) error {
+	buckets, err := findPrices(context.Background(), c.Param("id"), c.QueryParam("from"), c.QueryParam("to"), c.QueryParam("interval"), auth.FromContext(c), h.Coll, h.PricesColl)
+	if err != nil {
+		return err
+	}
+
+	return negotiation.Write(c, http.StatusOK, &buckets)
+}
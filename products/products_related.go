@@ -0,0 +1,232 @@
+package products
+
+import (
+	"context"
+	"fmt"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/negotiation"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RelatedWeights tune how much each signal adds to a related product's score
+type RelatedWeights struct {
+	Vendor     float64
+	Accessory  float64
+	PriceBand  float64
+	CoPurchase float64
+	// BandRatio is how far apart two normalized prices may be and still share a band, e.g. 0.2 for 20%
+	BandRatio float64
+}
+
+// ScoreReason explains the points one signal contributed
+type ScoreReason struct {
+	Signal string  `json:"signal"`
+	Points float64 `json:"points"`
+	Detail string  `json:"detail"`
+}
+
+// RelatedProduct describes a product scored against another one
+type RelatedProduct struct {
+	Product Product       `json:"product"`
+	Score   float64       `json:"score"`
+	Reasons []ScoreReason `json:"reasons"`
+}
+
+// RelatedIndex keeps the catalog and co-purchase counts in memory, rebuilt periodically by RunRelatedIndex
+type RelatedIndex struct {
+	mu          sync.RWMutex
+	products    map[primitive.ObjectID]Product
+	coPurchases map[primitive.ObjectID]map[primitive.ObjectID]int
+	builtAt     time.Time
+}
+
+// Rebuild reloads the catalog and counts how many users bought each pair of products
+func (idx *RelatedIndex) Rebuild(ctx context.Context, coll CollectionAPI, purchasesColl CollectionAPI) error {
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("unable to find products: %w", err)
+	}
+
+	var all []Product
+	if err := cursor.All(ctx, &all); err != nil {
+		return fmt.Errorf("unable to read the cursor: %w", err)
+	}
+
+	products := make(map[primitive.ObjectID]Product, len(all))
+	for _, product := range all {
+		products[product.ID] = product
+	}
+
+	coPurchases := make(map[primitive.ObjectID]map[primitive.ObjectID]int)
+	if purchasesColl != nil {
+		cursor, err := purchasesColl.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$group", Value: bson.M{"_id": "$user_id", "products": bson.M{"$addToSet": "$product_id"}}}},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to aggregate purchases: %w", err)
+		}
+
+		var baskets []struct {
+			Products []primitive.ObjectID `bson:"products"`
+		}
+		if err := cursor.All(ctx, &baskets); err != nil {
+			return fmt.Errorf("unable to read the cursor: %w", err)
+		}
+
+		for _, basket := range baskets {
+			for _, a := range basket.Products {
+				for _, b := range basket.Products {
+					if a == b {
+						continue
+					}
+					if coPurchases[a] == nil {
+						coPurchases[a] = make(map[primitive.ObjectID]int)
+					}
+					coPurchases[a][b]++
+				}
+			}
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.products = products
+	idx.coPurchases = coPurchases
+	idx.builtAt = time.Now()
+	return nil
+}
+
+// RunRelatedIndex builds the index straight away and then every interval until ctx is done
+func RunRelatedIndex(ctx context.Context, interval time.Duration, idx *RelatedIndex, coll CollectionAPI, purchasesColl CollectionAPI) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := idx.Rebuild(ctx, coll, purchasesColl); err != nil {
+			log.Errorf("Unable to rebuild the related index: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scoreRelated explains how related candidate is to product
+func scoreRelated(product, candidate Product, coPurchases int, weights RelatedWeights, currency string, rates map[string]float64) RelatedProduct {
+	related := RelatedProduct{Product: candidate, Reasons: []ScoreReason{}}
+	add := func(signal string, points float64, detail string) {
+		if points == 0 {
+			return
+		}
+		related.Score += points
+		related.Reasons = append(related.Reasons, ScoreReason{Signal: signal, Points: points, Detail: detail})
+	}
+
+	if product.Vendor == candidate.Vendor {
+		add("vendor", weights.Vendor, "same vendor "+candidate.Vendor)
+	}
+
+	accessories := make(map[string]bool)
+	for _, name := range product.Accessories {
+		accessories[name] = true
+	}
+	var shared []string
+	for _, name := range candidate.Accessories {
+		if accessories[name] {
+			shared = append(shared, name)
+		}
+	}
+	add("accessories", weights.Accessory*float64(len(shared)), fmt.Sprintf("shares %v", shared))
+
+	price := convertPrice(product.EffectivePrice(), product.Currency, currency, rates)
+	candidatePrice := convertPrice(candidate.EffectivePrice(), candidate.Currency, currency, rates)
+	if price != nil && candidatePrice != nil && *price > 0 {
+		if math.Abs(*candidatePrice-*price)/(*price) <= weights.BandRatio {
+			add("price_band", weights.PriceBand, fmt.Sprintf("%.2f %s is within %.0f%% of %.2f %s", *candidatePrice, currency, weights.BandRatio*100, *price, currency))
+		}
+	}
+
+	add("co_purchase", weights.CoPurchase*float64(coPurchases), fmt.Sprintf("bought together by %d users", coPurchases))
+
+	related.Score = math.Round(related.Score*100) / 100
+	return related
+}
+
+func findRelated(id string, limit int, claims auth.Claims, idx *RelatedIndex, weights RelatedWeights, currency string, rates map[string]float64) ([]RelatedProduct, *domainerr.Error) {
+	related := []RelatedProduct{}
+
+	_id, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Errorf("Unable to convert id to _id: %v", err)
+		return related, domainerr.New(domainerr.InvalidArgument, "Unable to convert id to _id")
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if idx.builtAt.IsZero() {
+		return related, domainerr.New(domainerr.Unavailable, "Related products are not indexed yet")
+	}
+
+	product, ok := idx.products[_id]
+	if !ok || !isVisible(product, claims) {
+		return related, domainerr.New(domainerr.NotFound, "Product does not exists")
+	}
+
+	for candidateID, candidate := range idx.products {
+		if candidateID == _id || !isVisible(candidate, claims) {
+			continue
+		}
+
+		scored := scoreRelated(product, candidate, idx.coPurchases[_id][candidateID], weights, currency, rates)
+		if scored.Score > 0 {
+			shapeProduct(&scored.Product, claims)
+			related = append(related, scored)
+		}
+	}
+
+	sort.Slice(related, func(i, j int) bool {
+		if related[i].Score != related[j].Score {
+			return related[i].Score > related[j].Score
+		}
+		return related[i].Product.ID.Hex() < related[j].Product.ID.Hex()
+	})
+	if len(related) > limit {
+		related = related[:limit]
+	}
+
+	return related, nil
+}
+
+func (h *ProductsHandler) GetRelated(c echo.Context) error {
+	limit := 10
+	if c.QueryParam("limit") != "" {
+		n, err := strconv.Atoi(c.QueryParam("limit"))
+		if err != nil || n <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+		limit = n
+	}
+
+	related, err := findRelated(c.Param("id"), limit, auth.FromContext(c), h.Related, h.RelatedWeights, h.CompareCurrency, h.ExchangeRates)
+	if err != nil {
+		return err
+	}
+
+	return negotiation.Write(c, http.StatusOK, &related)
+}
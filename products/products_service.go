@@ -0,0 +1,49 @@
+package products
+
+import (
+	"context"
+	"io"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"net/url"
+
+	"github.com/labstack/gommon/log"
+)
+
+// The methods below run the same logic as the HTTP handlers for callers that are not Echo handlers
+
+// Create validates and stores products like POST /products
+func (h *ProductsHandler) Create(ctx context.Context, products []Product, claims auth.Claims) ([]interface{}, *domainerr.Error) {
+	for _, product := range products {
+		if err := v.Struct(product); err != nil {
+			log.Errorf("Unable to validate the product %+v %v", product, err)
+			return nil, domainerr.New(domainerr.InvalidArgument, "Unable to validate the product").Wrap(err)
+		}
+
+		if err := checkWrites(Product{}, product, claims); err != nil {
+			return nil, err
+		}
+	}
+
+	return createProducts(ctx, products, claims, h.Events, h.Outbox, h.PricesColl, h.Coll)
+}
+
+// Find lists products like GET /products
+func (h *ProductsHandler) Find(ctx context.Context, qs url.Values, claims auth.Claims) ([]Product, *domainerr.Error) {
+	return findProducts(ctx, qs, claims, h.Coll)
+}
+
+// Get reads one product like GET /products/:id
+func (h *ProductsHandler) Get(ctx context.Context, id string, claims auth.Claims) (*Product, *domainerr.Error) {
+	return findProduct(ctx, id, claims, h.Coll)
+}
+
+// Update applies a JSON body to a product like PUT /products/:id
+func (h *ProductsHandler) Update(ctx context.Context, id string, body io.ReadCloser, claims auth.Claims) (*Product, *domainerr.Error) {
+	return updateProduct(ctx, id, body, claims, h.PriceWatcher, h.Events, h.Outbox, h.PricesColl, h.Coll)
+}
+
+// Delete removes a product like DELETE /products/:id
+func (h *ProductsHandler) Delete(ctx context.Context, id string, claims auth.Claims) (int, *domainerr.Error) {
+	return deleteProduct(ctx, id, claims, h.Events, h.Outbox, h.Coll)
+}
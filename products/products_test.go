@@ -3,20 +3,37 @@ package products
 import (
 	"context"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io/ioutil"
+	"learning-golang-restful-api/auth"
 	"learning-golang-restful-api/config"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/hypermedia"
+	"learning-golang-restful-api/productspb"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/labstack/echo"
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 var (
@@ -41,9 +58,15 @@ func init() {
 
 	db = C.Database(cfg.DBName)
 	coll = db.Collection(cfg.DBProductCollection)
-	h = &ProductsHandler{}
+	h = &ProductsHandler{
+		PricesColl:   db.Collection(cfg.DBPricesCollection),
+		PriceBuckets: cfg.FacetPriceBuckets,
+	}
 }
 
+// owner is the token of the user creating the products of TestProduct
+var owner = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "owner@gmail.com", "authorized": false})
+
 func TestMain(m *testing.M) {
 	ctx := context.Background()
 	testCode := m.Run()
@@ -73,6 +96,7 @@ func TestProduct(t *testing.T) {
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		e := echo.New()
 		ctx := e.NewContext(req, res)
+		ctx.Set("user", owner)
 		h.Coll = coll
 		assert.Nil(t, h.CreateProducts(ctx))
 		assert.Equal(t, http.StatusCreated, res.Code)
@@ -123,6 +147,42 @@ func TestProduct(t *testing.T) {
 		}
 	})
 
+	t.Run("Test get facets", func(t *testing.T) {
+		var facets Facets
+		req := httptest.NewRequest(http.MethodGet, "/products/facets?vendor=Amazon&price_buckets=0,200,500", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		h.Coll = coll
+		assert.Nil(t, h.GetFacets(ctx))
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		err := json.Unmarshal(res.Body.Bytes(), &facets)
+		assert.Nil(t, err)
+		assert.Equal(t, "Amazon", facets.Vendors[0].Value)
+		assert.Equal(t, "200-500", facets.Prices[0].Value)
+		assert.Len(t, facets.Accessories, 2)
+	})
+
+	t.Run("Test get products with facets", func(t *testing.T) {
+		var list struct {
+			Products []Product `json:"products"`
+			Facets   Facets    `json:"facets"`
+		}
+		req := httptest.NewRequest(http.MethodGet, "/products?vendor=Amazon&is_essential=false&facets=true", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		h.Coll = coll
+		assert.Nil(t, h.GetProducts(ctx))
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		err := json.Unmarshal(res.Body.Bytes(), &list)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, list.Products)
+		assert.Equal(t, "Amazon", list.Facets.Vendors[0].Value)
+	})
+
 	t.Run("Test get a product", func(t *testing.T) {
 		var product Product
 		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/products/%s", _ID), nil)
@@ -140,6 +200,44 @@ func TestProduct(t *testing.T) {
 		assert.Equal(t, "USD", product.Currency)
 	})
 
+	t.Run("Test get a product not modified", func(t *testing.T) {
+		var lastModified string
+		for _, ifModifiedSince := range []string{"", "last"} {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/products/%s", _ID), nil)
+			if ifModifiedSince != "" {
+				req.Header.Set(echo.HeaderIfModifiedSince, lastModified)
+			}
+			res := httptest.NewRecorder()
+			e := echo.New()
+			ctx := e.NewContext(req, res)
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(_ID)
+			h.Coll = coll
+			assert.Nil(t, h.GetProduct(ctx))
+			lastModified = res.Header().Get(echo.HeaderLastModified)
+			assert.NotEmpty(t, lastModified)
+			if ifModifiedSince != "" {
+				assert.Equal(t, http.StatusNotModified, res.Code)
+			}
+		}
+	})
+
+	t.Run("Test get products updated since", func(t *testing.T) {
+		var products []Product
+		req := httptest.NewRequest(http.MethodGet, "/products?updated_since=2000-01-01", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		h.Coll = coll
+		assert.Nil(t, h.GetProducts(ctx))
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		err := json.Unmarshal(res.Body.Bytes(), &products)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, products)
+		assert.False(t, products[0].UpdatedAt.IsZero())
+	})
+
 	t.Run("Test put a product", func(t *testing.T) {
 		var product Product
 		body := `
@@ -156,6 +254,7 @@ func TestProduct(t *testing.T) {
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		e := echo.New()
 		ctx := e.NewContext(req, res)
+		ctx.Set("user", owner)
 		ctx.SetParamNames("id")
 		ctx.SetParamValues(_ID)
 		h.Coll = coll
@@ -167,12 +266,105 @@ func TestProduct(t *testing.T) {
 		assert.Equal(t, "USD", product.Currency)
 	})
 
+	t.Run("Test put a product owned by someone else", func(t *testing.T) {
+		body := `{"name": "stolen"}`
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/products/%s", _ID), strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues(_ID)
+		ctx.Set("user", jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "someone@gmail.com"}))
+		h.Coll = coll
+		err := h.UpdateProduct(ctx)
+		assert.NotNil(t, err)
+		assert.Equal(t, domainerr.Forbidden, domainerr.KindOf(err))
+	})
+
+	t.Run("Test put a product with a token without user_id", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/products/%s", _ID), strings.NewReader(`{"name": "stolen"}`))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues(_ID)
+		ctx.Set("user", jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"authorized": false}))
+		h.Coll = coll
+		err := h.UpdateProduct(ctx)
+		assert.NotNil(t, err)
+		assert.Equal(t, domainerr.Forbidden, domainerr.KindOf(err))
+	})
+
+	t.Run("Test get product prices", func(t *testing.T) {
+		var buckets []PriceBucket
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/products/%s/prices?interval=day", _ID), nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues(_ID)
+		h.Coll = coll
+		err := h.GetPrices(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &buckets)
+		assert.Nil(t, err)
+		assert.Len(t, buckets, 1)
+		assert.Equal(t, 250, buckets[0].Last)
+	})
+
+	t.Run("Test prices of hidden or unknown products are not found", func(t *testing.T) {
+		ctx := context.Background()
+		draft := Product{ID: primitive.NewObjectID(), Name: "alexa", Status: StatusDraft, CreatedBy: "owner@gmail.com"}
+		_, err := coll.InsertOne(ctx, draft)
+		assert.Nil(t, err)
+		defer coll.DeleteOne(ctx, bson.M{"_id": draft.ID})
+
+		_, domainErr := findPrices(ctx, draft.ID.Hex(), "", "", "", auth.Claims{UserID: "someone@gmail.com"}, coll, h.PricesColl)
+		assert.Equal(t, domainerr.NotFound, domainErr.Kind)
+
+		_, domainErr = findPrices(ctx, primitive.NewObjectID().Hex(), "", "", "", auth.Claims{}, coll, h.PricesColl)
+		assert.Equal(t, domainerr.NotFound, domainErr.Kind)
+
+		buckets, domainErr := findPrices(ctx, draft.ID.Hex(), "", "", "", auth.Claims{UserID: "owner@gmail.com"}, coll, h.PricesColl)
+		assert.Nil(t, domainErr)
+		assert.Empty(t, buckets)
+	})
+
+	t.Run("Test scheduled products are published with an event", func(t *testing.T) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		publishAt := now.Add(-time.Minute)
+		scheduled := Product{ID: primitive.NewObjectID(), Name: "alexa", Vendor: "Amazon", Status: StatusScheduled, PublishAt: &publishAt}
+		_, err := coll.InsertOne(ctx, scheduled)
+		assert.Nil(t, err)
+		defer coll.DeleteOne(ctx, bson.M{"_id": scheduled.ID})
+
+		broker := &Broker{History: 10}
+		n, err := publishScheduled(ctx, now, broker, nil, coll)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+
+		backlog, _, unsubscribe := broker.Subscribe(0)
+		defer unsubscribe()
+		assert.Len(t, backlog, 1)
+		assert.Equal(t, EventUpdated, backlog[0].Type)
+		assert.Equal(t, StatusPublished, backlog[0].Product.Status)
+
+		n, err = publishScheduled(ctx, now, broker, nil, coll)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+	})
+
 	t.Run("Test delete a product", func(t *testing.T) {
 		var del int
 		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/products/%s", _ID), nil)
 		res := httptest.NewRecorder()
 		e := echo.New()
 		ctx := e.NewContext(req, res)
+		ctx.Set("user", owner)
 		ctx.SetParamNames("id")
 		ctx.SetParamValues(_ID)
 		h.Coll = coll
@@ -185,3 +377,455 @@ func TestProduct(t *testing.T) {
 	})
 
 }
+
+// recordingOutbox remembers whether each event was recorded internally
+type recordingOutbox struct {
+	internal []bool
+}
+
+func (o *recordingOutbox) Transact(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (o *recordingOutbox) Record(ctx context.Context, eventType string, aggregateID string, payload interface{}) error {
+	o.internal = append(o.internal, false)
+	return nil
+}
+
+func (o *recordingOutbox) RecordInternal(ctx context.Context, eventType string, aggregateID string, payload interface{}) error {
+	o.internal = append(o.internal, true)
+	return nil
+}
+
+func TestProductAccess(t *testing.T) {
+	user := auth.Claims{UserID: "user@gmail.com"}
+	admin := auth.Claims{UserID: "admin@gmail.com", IsAdmin: true}
+
+	t.Run("Test restricted fields are hidden from users", func(t *testing.T) {
+		product := Product{Name: "alexa", CostPrice: 100, InternalNotes: "margin is thin"}
+		shapeProduct(&product, user)
+		assert.Equal(t, "alexa", product.Name)
+		assert.Zero(t, product.CostPrice)
+		assert.Empty(t, product.InternalNotes)
+	})
+
+	t.Run("Test restricted fields are visible to admins", func(t *testing.T) {
+		product := Product{Name: "alexa", CostPrice: 100}
+		shapeProduct(&product, admin)
+		assert.Equal(t, 100, product.CostPrice)
+	})
+
+	t.Run("Test users cannot write restricted fields", func(t *testing.T) {
+		err := checkWrites(Product{}, Product{Name: "alexa", CostPrice: 100}, user)
+		assert.NotNil(t, err)
+		assert.Equal(t, domainerr.Forbidden, err.Kind)
+		assert.Nil(t, checkWrites(Product{}, Product{Name: "alexa"}, user))
+	})
+
+	t.Run("Test events leave without restricted fields", func(t *testing.T) {
+		product := Product{Name: "alexa", CostPrice: 100, InternalNotes: "margin is thin", DiscountRules: []DiscountRule{{MinQuantity: 10, Discount: 5}}}
+		b, err := json.Marshal(publicProduct(product))
+		assert.Nil(t, err)
+		assert.Contains(t, string(b), `"name":"alexa"`)
+		assert.NotContains(t, string(b), "cost_price")
+		assert.NotContains(t, string(b), "internal_notes")
+		assert.NotContains(t, string(b), "discount_rules")
+	})
+
+	t.Run("Test events of hidden products stay internal", func(t *testing.T) {
+		box := &recordingOutbox{}
+		assert.Nil(t, recordEvent(context.Background(), box, EventCreated, Product{Name: "alexa", Status: StatusPublished}))
+		assert.Nil(t, recordEvent(context.Background(), box, EventCreated, Product{Name: "alexa", Status: StatusDraft}))
+		assert.Equal(t, []bool{false, true}, box.internal)
+	})
+
+	t.Run("Test only the creator or an admin can modify", func(t *testing.T) {
+		assert.Nil(t, canModify(user, Product{CreatedBy: user.UserID}))
+		assert.Nil(t, canModify(admin, Product{}))
+		assert.Equal(t, domainerr.Forbidden, canModify(auth.Claims{UserID: "someone@gmail.com"}, Product{CreatedBy: user.UserID}).Kind)
+		// tokens without a user id match no creator, products without one are admin only
+		assert.Equal(t, domainerr.Forbidden, canModify(auth.Claims{}, Product{}).Kind)
+		assert.Equal(t, domainerr.Forbidden, canModify(user, Product{}).Kind)
+	})
+
+	t.Run("Test unchanged restricted fields are accepted", func(t *testing.T) {
+		before := Product{Name: "alexa", DiscountRules: []DiscountRule{{MinQuantity: 10, Discount: 5}}}
+		after := before
+		after.Name = "alexas"
+		assert.Nil(t, checkWrites(before, after, user))
+	})
+}
+
+func TestProductLifecycle(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Test products without status are published", func(t *testing.T) {
+		product := Product{}
+		assert.Nil(t, resolveStatus(&product, "", now))
+		assert.Equal(t, StatusPublished, product.Status)
+	})
+
+	t.Run("Test future publish_at schedules the product", func(t *testing.T) {
+		publishAt := now.Add(time.Hour)
+		product := Product{PublishAt: &publishAt}
+		assert.Nil(t, resolveStatus(&product, "", now))
+		assert.Equal(t, StatusScheduled, product.Status)
+	})
+
+	t.Run("Test scheduled without publish_at", func(t *testing.T) {
+		product := Product{Status: StatusScheduled}
+		err := resolveStatus(&product, "", now)
+		assert.NotNil(t, err)
+		assert.Equal(t, domainerr.InvalidArgument, err.Kind)
+	})
+
+	t.Run("Test drafts are only visible to the creator and admins", func(t *testing.T) {
+		product := Product{Status: StatusDraft, CreatedBy: "owner@gmail.com"}
+		assert.True(t, isVisible(product, auth.Claims{UserID: "owner@gmail.com"}))
+		assert.True(t, isVisible(product, auth.Claims{UserID: "admin@gmail.com", IsAdmin: true}))
+		assert.False(t, isVisible(product, auth.Claims{UserID: "someone@gmail.com"}))
+	})
+
+	t.Run("Test tokens without a user id only see published products", func(t *testing.T) {
+		assert.False(t, isVisible(Product{Status: StatusDraft}, auth.Claims{}))
+
+		filter := bson.M{}
+		visibleFilter(filter, auth.Claims{})
+		assert.Equal(t, bson.A{bson.M{"status": bson.M{"$in": bson.A{StatusPublished, nil}}}}, filter["$or"])
+	})
+
+	t.Run("Test legacy products are created when their id was", func(t *testing.T) {
+		id := primitive.NewObjectIDFromTimestamp(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+		assert.Equal(t, time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC), createdAt(Product{ID: id}))
+		assert.Equal(t, now, createdAt(Product{ID: id, CreatedAt: now}))
+	})
+
+	t.Run("Test the scheduler records who published and when", func(t *testing.T) {
+		product := published(Product{Status: StatusScheduled}, now)
+		assert.Equal(t, StatusPublished, product.Status)
+		assert.Equal(t, schedulerActor, product.UpdatedBy)
+		assert.Equal(t, []StatusChange{{From: StatusScheduled, To: StatusPublished, By: schedulerActor, At: now}}, product.StatusHistory)
+	})
+}
+
+func TestProductComparison(t *testing.T) {
+	products := []Product{
+		{Name: "alexa", Price: 100, Currency: "USD", Vendor: "Amazon", Accessories: []string{"charger", "remote"}},
+		{Name: "nest", Price: 85, Currency: "EUR", Vendor: "Amazon", Accessories: []string{"charger"}, CostPrice: 50},
+	}
+
+	t.Run("Test differing and matching fields", func(t *testing.T) {
+		differing, matching := compareFields(products, auth.Claims{})
+		assert.Contains(t, differing, "name")
+		assert.Contains(t, differing, "accessories")
+		assert.Contains(t, matching, "vendor")
+		assert.NotContains(t, differing, "cost_price")
+		assert.NotContains(t, matching, "_id")
+	})
+
+	t.Run("Test accessory presence", func(t *testing.T) {
+		accessories := compareAccessories(products)
+		assert.Equal(t, []AccessoryPresence{
+			{Name: "charger", Present: []bool{true, true}},
+			{Name: "remote", Present: []bool{true, false}},
+		}, accessories)
+	})
+
+	t.Run("Test price normalization", func(t *testing.T) {
+		rates := map[string]float64{"USD": 1, "EUR": 0.85}
+		assert.Equal(t, 100.0, *convertPrice(85, "EUR", "USD", rates))
+		assert.Nil(t, convertPrice(85, "XYZ", "USD", rates))
+	})
+}
+
+func TestRelatedProducts(t *testing.T) {
+	weights := RelatedWeights{Vendor: 3, Accessory: 1, PriceBand: 2, CoPurchase: 0.5, BandRatio: 0.2}
+	rates := map[string]float64{"USD": 1}
+	product := Product{Name: "alexa", Price: 100, Currency: "USD", Vendor: "Amazon", Accessories: []string{"charger", "remote"}}
+
+	t.Run("Test every signal is scored and explained", func(t *testing.T) {
+		candidate := Product{Name: "echo", Price: 110, Currency: "USD", Vendor: "Amazon", Accessories: []string{"charger"}}
+		related := scoreRelated(product, candidate, 2, weights, "USD", rates)
+		assert.Equal(t, 7.0, related.Score)
+		assert.Len(t, related.Reasons, 4)
+		assert.Equal(t, "vendor", related.Reasons[0].Signal)
+	})
+
+	t.Run("Test unrelated product scores nothing", func(t *testing.T) {
+		candidate := Product{Name: "pixel", Price: 900, Currency: "USD", Vendor: "Google"}
+		related := scoreRelated(product, candidate, 0, weights, "USD", rates)
+		assert.Zero(t, related.Score)
+		assert.Empty(t, related.Reasons)
+	})
+}
+
+func TestProductPrices(t *testing.T) {
+	t.Run("Test a plain to date includes the whole day", func(t *testing.T) {
+		filter := bson.M{}
+		assert.Nil(t, endFilter(filter, "2026-01-02"))
+		assert.Equal(t, bson.M{"$lt": time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC)}, filter)
+
+		filter = bson.M{}
+		assert.Nil(t, endFilter(filter, "2026-01-02T10:00:00Z"))
+		assert.Equal(t, bson.M{"$lte": time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)}, filter)
+
+		assert.NotNil(t, endFilter(bson.M{}, "yesterday"))
+	})
+}
+
+func TestProductEvents(t *testing.T) {
+	broker := &Broker{History: 2}
+	amazon := Product{ID: primitive.NewObjectID(), Name: "alexa", Vendor: "Amazon"}
+	google := Product{ID: primitive.NewObjectID(), Name: "nest", Vendor: "Google"}
+	broker.publishChange(EventCreated, amazon)
+	broker.publishChange(EventCreated, google)
+	broker.publishChange(EventDeleted, amazon)
+
+	t.Run("Test resume keeps only the latest history", func(t *testing.T) {
+		backlog, _, unsubscribe := broker.Subscribe(0)
+		defer unsubscribe()
+		assert.Len(t, backlog, 2)
+		assert.Equal(t, uint64(2), backlog[0].ID)
+		assert.Nil(t, backlog[1].Product)
+	})
+
+	t.Run("Test deletes keep the vendor and the visibility of the product", func(t *testing.T) {
+		draft := Product{ID: primitive.NewObjectID(), Vendor: "Amazon", Status: StatusDraft, CreatedBy: "owner@gmail.com"}
+		payload, err := json.Marshal(publicProduct(draft))
+		assert.Nil(t, err)
+		doc, err := bson.Marshal(bson.M{"type": EventDeleted, "aggregate_id": draft.ID.Hex(), "payload": payload})
+		assert.Nil(t, err)
+
+		c := change{OperationType: "insert", FullDocument: doc}
+		c.NS.Coll = "outbox"
+		c.Token.Data = "826A"
+		event, ok := eventOf(c, "products")
+		assert.True(t, ok)
+		assert.Equal(t, EventDeleted, event.Type)
+		assert.Equal(t, "826A", event.Token)
+		assert.True(t, matchesEvent(event, "Amazon", draft.ID.Hex()))
+		_, ok = event.For(auth.Claims{UserID: "someone@gmail.com"})
+		assert.False(t, ok)
+		_, ok = event.For(auth.Claims{UserID: "owner@gmail.com"})
+		assert.True(t, ok)
+	})
+
+	t.Run("Test handlers leave the events to the change stream", func(t *testing.T) {
+		streamed := &Broker{History: 2, changeStream: true}
+		streamed.publishChange(EventUpdated, amazon)
+		streamed.publishChange(EventDeleted, amazon)
+
+		backlog, _, unsubscribe := streamed.Subscribe(0)
+		defer unsubscribe()
+		assert.Empty(t, backlog)
+	})
+
+	t.Run("Test a token without a change stream streams from now", func(t *testing.T) {
+		backlog, events, unsubscribe := (&ProductsHandler{Events: broker}).subscribe(context.Background(), "826A")
+		defer unsubscribe()
+		assert.Empty(t, backlog)
+		assert.NotNil(t, events)
+	})
+
+	t.Run("Test a nil broker streams nothing", func(t *testing.T) {
+		var nilBroker *Broker
+		backlog, events, unsubscribe := nilBroker.Subscribe(0)
+		defer unsubscribe()
+		assert.Empty(t, backlog)
+		assert.Nil(t, events)
+	})
+
+	t.Run("Test stream resumes after Last-Event-ID with a vendor filter", func(t *testing.T) {
+		reqCtx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest(http.MethodGet, "/products/events?vendor=Amazon", nil).WithContext(reqCtx)
+		req.Header.Set("Last-Event-ID", "1")
+		res := httptest.NewRecorder()
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		h.Events = broker
+		assert.Nil(t, h.StreamEvents(ctx))
+		assert.Equal(t, "text/event-stream", res.Header().Get(echo.HeaderContentType))
+		assert.Contains(t, res.Body.String(), "id: 3\nevent: product.deleted\n")
+		assert.NotContains(t, res.Body.String(), "Google")
+	})
+}
+
+func TestProductGRPC(t *testing.T) {
+	secret := []byte("grpc-secret")
+	lis := bufconn.Listen(1024 * 1024)
+	gs := grpc.NewServer(grpc.UnaryInterceptor(auth.UnaryInterceptor(secret)))
+	productspb.RegisterProductServiceServer(gs, &ProductServer{Handler: h})
+	go gs.Serve(lis)
+	defer gs.Stop()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := productspb.NewProductServiceClient(conn)
+
+	t.Run("Test call without a token is unauthenticated", func(t *testing.T) {
+		_, err := client.Get(context.Background(), &productspb.GetRequest{Id: primitive.NewObjectID().Hex()})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("Test token in metadata reaches the shared logic", func(t *testing.T) {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "a@mail.com", "authorized": false}).SignedString(secret)
+		ctx := metadata.AppendToOutgoingContext(context.Background(), auth.MetadataKey, "Bearer "+token)
+		_, err := client.Get(ctx, &productspb.GetRequest{Id: "not-an-id"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Equal(t, "Unable to convert id to _id", status.Convert(err).Message())
+	})
+
+	t.Run("Test domain errors map to grpc codes", func(t *testing.T) {
+		assert.Equal(t, codes.PermissionDenied, status.Code(grpcError(domainerr.New(domainerr.Forbidden, "no"))))
+		assert.Equal(t, codes.NotFound, status.Code(grpcError(domainerr.New(domainerr.NotFound, "no"))))
+		assert.Equal(t, codes.Internal, status.Code(grpcError(domainerr.New(domainerr.Internal, "no"))))
+		assert.Equal(t, codes.Internal, status.Code(grpcError(domainerr.New(domainerr.Kind(99), "no"))))
+	})
+
+	t.Run("Test products convert to and from the wire", func(t *testing.T) {
+		publishAt := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+		product := Product{
+			ID: primitive.NewObjectID(), Name: "iphone", Price: 1000, Currency: "USD", Vendor: "Apple",
+			Accessories: []string{"charger"}, PublishAt: &publishAt,
+			DiscountRules: []DiscountRule{{MinQuantity: 2, Discount: 5}},
+		}
+		pb := toProto(product)
+		assert.Equal(t, product.ID.Hex(), pb.Id)
+		assert.Nil(t, pb.CreatedAt)
+
+		back := fromProto(pb)
+		back.ID = product.ID
+		assert.Equal(t, product, back)
+	})
+
+	t.Run("Test update body follows the mask", func(t *testing.T) {
+		body, err := updateBody(Product{Price: 900}, nil)
+		assert.Nil(t, err)
+		assert.JSONEq(t, `{"price": 900}`, string(body))
+
+		body, err = updateBody(Product{Price: 900}, []string{"price", "discount"})
+		assert.Nil(t, err)
+		assert.JSONEq(t, `{"price": 900, "discount": 0}`, string(body))
+
+		_, err = updateBody(Product{}, []string{"_id"})
+		assert.Equal(t, domainerr.InvalidArgument, err.Kind)
+	})
+}
+
+func TestProductV2(t *testing.T) {
+	t.Run("Test products map to and from v2", func(t *testing.T) {
+		product := Product{ID: primitive.NewObjectID(), Name: "alexa", Price: 200, Discount: 10, Currency: "USD", CostPrice: 120, Vendor: "Amazon"}
+		v2 := toV2(product)
+		assert.Equal(t, Money{Amount: 200, Currency: "USD"}, v2.Price)
+		assert.Equal(t, Money{Amount: 180, Currency: "USD"}, v2.EffectivePrice)
+		assert.Equal(t, &Money{Amount: 120, Currency: "USD"}, v2.CostPrice)
+		assert.Equal(t, []string{}, v2.Accessories)
+
+		back, err := fromV2(v2)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, back.Price)
+		assert.Equal(t, "USD", back.Currency)
+		assert.Equal(t, 120, back.CostPrice)
+
+		v2.CostPrice.Currency = "EUR"
+		_, err = fromV2(v2)
+		assert.Equal(t, domainerr.InvalidArgument, err.Kind)
+	})
+
+	t.Run("Test v2 update bodies become v1 fields", func(t *testing.T) {
+		body, err := v1Patch(strings.NewReader(`{"id": "x", "name": "echo", "price": {"amount": 90, "currency": "EUR"}, "cost_price": null}`))
+		assert.Nil(t, err)
+		b, _ := ioutil.ReadAll(body)
+		assert.JSONEq(t, `{"name": "echo", "price": 90, "currency": "EUR", "cost_price": 0}`, string(b))
+
+		_, err = v1Patch(strings.NewReader(`{"price": 90}`))
+		assert.Equal(t, domainerr.InvalidArgument, err.Kind)
+	})
+
+}
+
+func TestProductErrors(t *testing.T) {
+	t.Run("Test malformed ids are invalid arguments", func(t *testing.T) {
+		_, err := objectID("not-an-id")
+		assert.Equal(t, domainerr.InvalidArgument, err.Kind)
+
+		_, err = objectID(primitive.NewObjectID().Hex())
+		assert.Nil(t, err)
+	})
+
+	t.Run("Test filters match the stored type", func(t *testing.T) {
+		filter, err := productsFilter(url.Values{"is_essential": {"true"}, "vendor": {"Amazon"}, "facets": {"true"}}, auth.Claims{})
+		assert.Nil(t, err)
+		assert.Equal(t, true, filter["is_essential"])
+		assert.Equal(t, "Amazon", filter["vendor"])
+		assert.NotContains(t, filter, "facets")
+
+		_, err = productsFilter(url.Values{"is_essential": {"maybe"}}, auth.Claims{})
+		assert.Equal(t, domainerr.InvalidArgument, err.Kind)
+	})
+
+	t.Run("Test a malformed update body is a bad request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/products/"+primitive.NewObjectID().Hex(), strings.NewReader("<product><name>alexa"))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationXML)
+		ctx := echo.New().NewContext(req, httptest.NewRecorder())
+		err := (&ProductsHandler{}).UpdateProduct(ctx)
+		assert.Equal(t, echo.NewHTTPError(http.StatusBadRequest, "Unable to decode from req body to struct"), err)
+	})
+
+	t.Run("Test missing products are not found", func(t *testing.T) {
+		assert.Equal(t, domainerr.NotFound, findError(mongo.ErrNoDocuments).Kind)
+		assert.Equal(t, domainerr.Internal, findError(mongo.ErrClientDisconnected).Kind)
+	})
+}
+
+func TestProductHypermedia(t *testing.T) {
+	products := []Product{
+		{ID: primitive.NewObjectID(), Name: "alexa", Vendor: "Amazon", Accessories: []string{"charger"}},
+		{ID: primitive.NewObjectID(), Name: "echo", Vendor: "Amazon", Accessories: []string{"charger", "remote"}},
+	}
+
+	t.Run("Test links stay in the version and relations are included once", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/v2/products?limit=2&offset=2&include=vendor,accessories", nil)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		c.SetPath("/v2/products")
+
+		doc := productsDocument(c, products, true, v2Attributes)
+		assert.Equal(t, "/v2/products/"+products[0].ID.Hex(), doc.Data[0].Links["self"])
+		assert.Len(t, doc.Included, 3)
+		assert.Equal(t, "/v2/products?vendor=Amazon", doc.Included[0].Links["related"])
+		assert.Equal(t, "/v2/products?include=vendor%2Caccessories&limit=2&offset=4", doc.Links["next"])
+		assert.Equal(t, "/v2/products?include=vendor%2Caccessories&limit=2&offset=0", doc.Links["prev"])
+	})
+
+	t.Run("Test no page links without limit", func(t *testing.T) {
+		links := pageLinks(httptest.NewRequest(http.MethodGet, "/products?vendor=Amazon", nil).URL, 2)
+		assert.Equal(t, hypermedia.Links{"self": "/products?vendor=Amazon"}, links)
+	})
+}
+
+func TestProductXML(t *testing.T) {
+	t.Run("Test products go out as <product> with a hex id and come back", func(t *testing.T) {
+		product := Product{ID: primitive.NewObjectID(), Name: "alexa", Price: 100, Currency: "USD", Vendor: "Amazon", Accessories: []string{"charger"}}
+		b, err := xml.Marshal(product)
+		assert.Nil(t, err)
+		assert.Contains(t, string(b), "<product><_id>"+product.ID.Hex()+"</_id><name>alexa</name>")
+		assert.Contains(t, string(b), "<accessories><accessory>charger</accessory></accessories>")
+
+		var decoded Product
+		assert.Nil(t, xml.Unmarshal(b, &decoded))
+		assert.Equal(t, product.Name, decoded.Name)
+		assert.Equal(t, product.Accessories, decoded.Accessories)
+		assert.True(t, decoded.ID.IsZero())
+	})
+
+	t.Run("Test v2 products keep their money", func(t *testing.T) {
+		b, err := xml.Marshal(toV2(Product{Name: "alexa", Price: 100, Currency: "USD", Discount: 10}))
+		assert.Nil(t, err)
+		assert.Contains(t, string(b), "<price><amount>100</amount><currency>USD</currency></price>")
+		assert.Contains(t, string(b), "<effective_price><amount>90</amount><currency>USD</currency></effective_price>")
+	})
+}
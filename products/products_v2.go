@@ -0,0 +1,257 @@
+package products
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/hypermedia"
+	"learning-golang-restful-api/negotiation"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Money is an amount together with its currency, v2 never sends one without the other
+type Money struct {
+	Amount   int    `json:"amount" xml:"amount" validate:"min=0"`
+	Currency string `json:"currency" xml:"currency" validate:"required,len=3"`
+}
+
+// ProductV2 is how /v2 represents a product, Product stays the storage and v1 shape
+type ProductV2 struct {
+	ID             primitive.ObjectID `json:"id" xml:"-"`
+	Name           string             `json:"name" xml:"name" validate:"required,max=10"`
+	Price          Money              `json:"price" xml:"price" validate:"required"`
+	Discount       int                `json:"discount" xml:"discount" validate:"min=0,max=100"`
+	EffectivePrice Money              `json:"effective_price" xml:"effective_price"`
+	Vendor         string             `json:"vendor" xml:"vendor" validate:"required"`
+	Accessories    []string           `json:"accessories" xml:"accessories>accessory" validate:"required"`
+	IsEssential    bool               `json:"is_essential" xml:"is_essential"`
+	PurchaseLimit  int                `json:"purchase_limit,omitempty" xml:"purchase_limit,omitempty" validate:"min=0"`
+	CostPrice      *Money             `json:"cost_price,omitempty" xml:"cost_price,omitempty"`
+	InternalNotes  string             `json:"internal_notes,omitempty" xml:"internal_notes,omitempty"`
+	DiscountRules  []DiscountRule     `json:"discount_rules,omitempty" xml:"discount_rules>discount_rule,omitempty" validate:"dive"`
+	CreatedBy      string             `json:"created_by,omitempty" xml:"created_by,omitempty"`
+	UpdatedBy      string             `json:"updated_by,omitempty" xml:"updated_by,omitempty"`
+	Status         string             `json:"status" xml:"status" validate:"omitempty,oneof=draft scheduled published archived"`
+	PublishAt      *time.Time         `json:"publish_at,omitempty" xml:"publish_at,omitempty"`
+	StatusHistory  []StatusChange     `json:"status_history,omitempty" xml:"status_history>status_change,omitempty"`
+	CreatedAt      time.Time          `json:"created_at" xml:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at" xml:"updated_at"`
+}
+
+// MarshalXML writes a product as <product> with its hex id
+func (p ProductV2) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type product ProductV2
+	start.Name.Local = "product"
+	return e.EncodeElement(struct {
+		ID string `xml:"id"`
+		product
+	}{p.ID.Hex(), product(p)}, start)
+}
+
+// toV2 maps a stored product to its v2 representation
+func toV2(p Product) ProductV2 {
+	product := ProductV2{
+		ID:             p.ID,
+		Name:           p.Name,
+		Price:          Money{Amount: p.Price, Currency: p.Currency},
+		Discount:       p.Discount,
+		EffectivePrice: Money{Amount: p.EffectivePrice(), Currency: p.Currency},
+		Vendor:         p.Vendor,
+		Accessories:    p.Accessories,
+		IsEssential:    p.IsEssential,
+		PurchaseLimit:  p.PurchaseLimit,
+		InternalNotes:  p.InternalNotes,
+		DiscountRules:  p.DiscountRules,
+		CreatedBy:      p.CreatedBy,
+		UpdatedBy:      p.UpdatedBy,
+		Status:         p.Status,
+		PublishAt:      p.PublishAt,
+		StatusHistory:  p.StatusHistory,
+		CreatedAt:      p.CreatedAt,
+		UpdatedAt:      p.UpdatedAt,
+	}
+	if product.Accessories == nil {
+		product.Accessories = []string{}
+	}
+	if p.CostPrice != 0 {
+		product.CostPrice = &Money{Amount: p.CostPrice, Currency: p.Currency}
+	}
+	return product
+}
+
+func toV2List(products []Product) []ProductV2 {
+	list := make([]ProductV2, 0, len(products))
+	for _, product := range products {
+		list = append(list, toV2(product))
+	}
+	return list
+}
+
+// fromV2 maps a v2 body to a product, server kept fields are left to the logic functions
+func fromV2(p ProductV2) (Product, *domainerr.Error) {
+	product := Product{
+		Name:          p.Name,
+		Price:         p.Price.Amount,
+		Currency:      p.Price.Currency,
+		Discount:      p.Discount,
+		Vendor:        p.Vendor,
+		Accessories:   p.Accessories,
+		IsEssential:   p.IsEssential,
+		PurchaseLimit: p.PurchaseLimit,
+		InternalNotes: p.InternalNotes,
+		DiscountRules: p.DiscountRules,
+		Status:        p.Status,
+		PublishAt:     p.PublishAt,
+	}
+	if p.CostPrice != nil {
+		if p.CostPrice.Currency != p.Price.Currency {
+			return product, domainerr.New(domainerr.InvalidArgument, "The cost price must be in the currency of the price")
+		}
+		product.CostPrice = p.CostPrice.Amount
+	}
+	return product, nil
+}
+
+// v1Patch rewrites a partial v2 body into the v1 fields updateProduct merges into the product
+func v1Patch(body io.Reader) (io.ReadCloser, *domainerr.Error) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&fields); err != nil {
+		log.Errorf("Unable to decode from req body: %v", err)
+		return nil, domainerr.New(domainerr.InvalidArgument, "Unable to decode from req body")
+	}
+
+	// read only in v2
+	delete(fields, "id")
+	delete(fields, "effective_price")
+
+	var price Money
+	if raw, ok := fields["price"]; ok {
+		if err := json.Unmarshal(raw, &price); err != nil {
+			return nil, domainerr.New(domainerr.InvalidArgument, "The price must be an amount and a currency")
+		}
+		fields["price"], _ = json.Marshal(price.Amount)
+		fields["currency"], _ = json.Marshal(price.Currency)
+	}
+
+	if raw, ok := fields["cost_price"]; ok {
+		var cost *Money
+		if err := json.Unmarshal(raw, &cost); err != nil {
+			return nil, domainerr.New(domainerr.InvalidArgument, "The cost price must be an amount and a currency")
+		}
+		amount := 0
+		if cost != nil {
+			if price.Currency != "" && cost.Currency != price.Currency {
+				return nil, domainerr.New(domainerr.InvalidArgument, "The cost price must be in the currency of the price")
+			}
+			amount = cost.Amount
+		}
+		fields["cost_price"], _ = json.Marshal(amount)
+	}
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, domainerr.New(domainerr.Internal, "Unable to encode the body")
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (h *ProductsHandler) CreateProductsV2(c echo.Context) error {
+	var body []ProductV2
+	if err := negotiation.Bind(c, &body); err != nil {
+		log.Errorf("Unable to bind: %v", err)
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to bind data")
+	}
+
+	products := make([]Product, 0, len(body))
+	for _, p := range body {
+		product, err := fromV2(p)
+		if err != nil {
+			return err
+		}
+		products = append(products, product)
+	}
+
+	claims := auth.FromContext(c)
+	if _, err := h.Create(context.Background(), products, claims); err != nil {
+		return err
+	}
+
+	// v2 answers with the created products rather than their ids
+	shapeProducts(products, claims)
+	return hypermedia.Render(c, http.StatusCreated, productsDocument(c, products, true, v2Attributes), toV2List(products))
+}
+
+func (h *ProductsHandler) GetProductsV2(c echo.Context) error {
+	products, err := h.Find(context.Background(), c.QueryParams(), auth.FromContext(c))
+	if err != nil {
+		return err
+	}
+
+	doc := productsDocument(c, products, true, v2Attributes)
+	body, facetsErr := h.withFacets(c, &doc, toV2List(products))
+	if facetsErr != nil {
+		return facetsErr
+	}
+
+	return hypermedia.Render(c, http.StatusOK, doc, body)
+}
+
+func (h *ProductsHandler) GetProductV2(c echo.Context) error {
+	product, err := h.Get(context.Background(), c.Param("id"), auth.FromContext(c))
+	if err != nil {
+		return err
+	}
+
+	if notModified(c, *product) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return hypermedia.Render(c, http.StatusOK, productsDocument(c, []Product{*product}, false, v2Attributes), toV2(*product))
+}
+
+func (h *ProductsHandler) UpdateProductV2(c echo.Context) error {
+	fields, bodyErr := negotiation.JSONBody(c, ProductV2{})
+	if bodyErr == echo.ErrUnsupportedMediaType {
+		return bodyErr
+	} else if bodyErr != nil {
+		log.Errorf("Unable to decode from req body: %v", bodyErr)
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to decode from req body")
+	}
+
+	body, err := v1Patch(fields)
+	if err != nil {
+		return err
+	}
+
+	product, err := h.Update(context.Background(), c.Param("id"), body, auth.FromContext(c))
+	if err != nil {
+		return err
+	}
+
+	return hypermedia.Render(c, http.StatusOK, productsDocument(c, []Product{*product}, false, v2Attributes), toV2(*product))
+}
+
+func (h *ProductsHandler) DeleteProductV2(c echo.Context) error {
+	deleted, err := h.Delete(context.Background(), c.Param("id"), auth.FromContext(c))
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return domainerr.New(domainerr.NotFound, "Product does not exists")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,4 @@
+// Package productspb holds the ProductService protobuf definition and the code generated from it.
+package productspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative products.proto
@@ -0,0 +1,41 @@
+package purchases
+
+import (
+	"learning-golang-restful-api/problem"
+	"time"
+
+	"github.com/go-playground/validator"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	v = problem.NewValidator()
+)
+
+// Purchase describes units of a product bought by a user
+type Purchase struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    string             `json:"user_id" bson:"user_id"`
+	ProductID primitive.ObjectID `json:"product_id" bson:"product_id" validate:"required"`
+	Quantity  int                `json:"quantity" bson:"quantity" validate:"required,min=1"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Allowance is how many more units of an essential product a user may buy within the window
+type Allowance struct {
+	ProductID primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Name      string             `json:"name" bson:"name"`
+	Limit     int                `json:"limit" bson:"limit"`
+	Remaining int                `json:"remaining" bson:"remaining"`
+	Quantity  int                `json:"quantity" bson:"quantity"`
+}
+
+// PurchaseValidator a purchase validator
+type PurchaseValidator struct {
+	validator *validator.Validate
+}
+
+// Validates a purchase
+func (v *PurchaseValidator) Validate(i interface{}) error {
+	return v.validator.Struct(i)
+}
@@ -0,0 +1,243 @@
+package purchases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/products"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PurchasesHandler struct {
+	Coll         CollectionAPI
+	ProductsColl CollectionAPI
+	// CountersColl keeps a document per user and essential product that every checkout of it writes,
+	// so concurrent checkouts of the same product by the same user conflict and run one after the other.
+	// The limit is counted from the purchases within Window, a counter only expires once it is idle for Window
+	CountersColl CollectionAPI
+	// Transactions runs a checkout atomically, nil runs it without a transaction
+	Transactions Transactor
+	// DefaultLimit caps essential products that do not set their own purchase_limit
+	DefaultLimit int
+	// Window is the rolling period the limit applies to
+	Window   time.Duration
+	Notifier Notifier
+}
+
+func purchasedSince(ctx context.Context, userID string, productID primitive.ObjectID, since time.Time, coll CollectionAPI) (int, *echo.HTTPError) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": userID, "product_id": productID, "created_at": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$quantity"}}}},
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Errorf("Unable to aggregate purchases: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Unable to aggregate purchases")
+	}
+
+	var totals []struct {
+		Total int `bson:"total"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Unable to read the cursor")
+	}
+
+	if len(totals) == 0 {
+		return 0, nil
+	}
+	return totals[0].Total, nil
+}
+
+func purchaseLimit(product products.Product, defaultLimit int) int {
+	if product.PurchaseLimit > 0 {
+		return product.PurchaseLimit
+	}
+	return defaultLimit
+}
+
+// quantitiesOf sums the units per product, the same product may appear several times in one cart
+func quantitiesOf(purchases []Purchase) map[primitive.ObjectID]int {
+	quantities := make(map[primitive.ObjectID]int)
+	for _, purchase := range purchases {
+		quantities[purchase.ProductID] += purchase.Quantity
+	}
+	return quantities
+}
+
+// findPurchasable finds a product that can be bought, products that are not published are not found
+func findPurchasable(ctx context.Context, productID primitive.ObjectID, productsColl CollectionAPI) (products.Product, *echo.HTTPError) {
+	var product products.Product
+	err := productsColl.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err == mongo.ErrNoDocuments || (err == nil && !product.IsPublished()) {
+		return products.Product{}, echo.NewHTTPError(http.StatusNotFound, "Unable to find product "+productID.Hex())
+	}
+	if err != nil {
+		log.Errorf("Unable to decode FindOne res: %v", err)
+		return products.Product{}, echo.NewHTTPError(http.StatusInternalServerError, "Unable to decode FindOne res")
+	}
+	return product, nil
+}
+
+// checkLimits returns the allowances of the essential products in quantities, or a 422 naming the
+// first one the cart goes over. With reserve set the counter of every essential product is written,
+// in a transaction this makes concurrent checkouts of the same products conflict, and the counter
+// is kept until the window of the checkout has passed
+func checkLimits(ctx context.Context, userID string, quantities map[primitive.ObjectID]int, defaultLimit int, window time.Duration, reserve bool, productsColl, countersColl, coll CollectionAPI) ([]Allowance, *echo.HTTPError) {
+	var allowances []Allowance
+
+	now := time.Now()
+	for productID, quantity := range quantities {
+		product, err := findPurchasable(ctx, productID, productsColl)
+		if err != nil {
+			return nil, err
+		}
+
+		if !product.IsEssential {
+			continue
+		}
+
+		if reserve && countersColl != nil {
+			counter := bson.D{{Key: "user_id", Value: userID}, {Key: "product_id", Value: productID}}
+			_, err := countersColl.UpdateOne(ctx, bson.M{"_id": counter}, bson.M{"$set": bson.M{"expires_at": now.Add(window)}}, options.Update().SetUpsert(true))
+			if err != nil {
+				log.Errorf("Unable to reserve the purchase: %v", err)
+				return nil, echo.NewHTTPError(http.StatusInternalServerError, "Unable to reserve the purchase").SetInternal(err)
+			}
+		}
+
+		limit := purchaseLimit(product, defaultLimit)
+		bought, err := purchasedSince(ctx, userID, productID, now.Add(-window), coll)
+		if err != nil {
+			return nil, err
+		}
+
+		remaining := limit - bought
+		if remaining < 0 {
+			remaining = 0
+		}
+		if quantity > remaining {
+			log.Errorf("Purchase limit reached for %s by %s", productID.Hex(), userID)
+			return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, echo.Map{
+				"message":    fmt.Sprintf("Purchase limit reached for %s: %d of %d units remaining within %s", product.Name, remaining, limit, window),
+				"product_id": productID,
+				"limit":      limit,
+				"remaining":  remaining,
+			})
+		}
+
+		allowances = append(allowances, Allowance{ProductID: productID, Name: product.Name, Limit: limit, Remaining: remaining, Quantity: quantity})
+	}
+	return allowances, nil
+}
+
+func createPurchases(ctx context.Context, userID string, purchases []Purchase, defaultLimit int, window time.Duration, notifier Notifier, tx Transactor, productsColl, countersColl, coll CollectionAPI) ([]interface{}, *echo.HTTPError) {
+	var insertedIds []interface{}
+	var created []Purchase
+
+	// the limits are checked and the purchases inserted together, a checkout is bought whole or not at all
+	checkout := func(ctx context.Context) error {
+		insertedIds, created = nil, nil
+
+		if _, err := checkLimits(ctx, userID, quantitiesOf(purchases), defaultLimit, window, true, productsColl, countersColl, coll); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, purchase := range purchases {
+			purchase.ID = primitive.NewObjectID()
+			purchase.UserID = userID
+			purchase.CreatedAt = now
+
+			res, err := coll.InsertOne(ctx, purchase)
+			if err != nil {
+				return err
+			}
+
+			insertedIds = append(insertedIds, res.InsertedID)
+			created = append(created, purchase)
+		}
+		return nil
+	}
+
+	var err error
+	if tx == nil {
+		err = checkout(ctx)
+	} else {
+		err = tx.Transact(ctx, checkout)
+	}
+	if err != nil {
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			return nil, httpErr
+		}
+		log.Errorf("Unable to insert: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Unable to insert")
+	}
+
+	if notifier != nil {
+		notifier.Purchased(ctx, created)
+	}
+
+	return insertedIds, nil
+}
+
+// bindPurchases binds and validates a cart of purchases
+func bindPurchases(c echo.Context) ([]Purchase, error) {
+	c.Echo().Validator = &PurchaseValidator{validator: v}
+
+	var purchases []Purchase
+	if err := c.Bind(&purchases); err != nil {
+		log.Errorf("Unable to bind: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Unable to bind data")
+	}
+
+	for _, purchase := range purchases {
+		if err := c.Validate(purchase); err != nil {
+			log.Errorf("Unable to validate the purchase %+v %v", purchase, err)
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Unable to validate the purchase").SetInternal(err)
+		}
+	}
+	return purchases, nil
+}
+
+// CheckCart checks a cart against the purchase limits without buying it,
+// answering the remaining allowance of its essential products
+func (h *PurchasesHandler) CheckCart(c echo.Context) error {
+	purchases, err := bindPurchases(c)
+	if err != nil {
+		return err
+	}
+
+	allowances, httpErr := checkLimits(context.Background(), auth.FromContext(c).UserID, quantitiesOf(purchases), h.DefaultLimit, h.Window, false, h.ProductsColl, h.CountersColl, h.Coll)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	return c.JSON(http.StatusOK, allowances)
+}
+
+// CreatePurchases checks out a cart of purchases, enforcing limits on essential products
+func (h *PurchasesHandler) CreatePurchases(c echo.Context) error {
+	purchases, err := bindPurchases(c)
+	if err != nil {
+		return err
+	}
+
+	ids, httpErr := createPurchases(context.Background(), auth.FromContext(c).UserID, purchases, h.DefaultLimit, h.Window, h.Notifier, h.Transactions, h.ProductsColl, h.CountersColl, h.Coll)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	return c.JSON(http.StatusCreated, ids)
+}
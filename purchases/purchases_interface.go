@@ -0,0 +1,28 @@
+package purchases
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// CollectionAPI collection interface
+	CollectionAPI interface {
+		InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+		FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+		Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+		UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	}
+
+	// Transactor runs fn in a transaction, every write made with the ctx given to fn commits or aborts together
+	Transactor interface {
+		Transact(ctx context.Context, fn func(ctx context.Context) error) error
+	}
+
+	// Notifier is told about completed purchases, e.g. to push inventory changes
+	Notifier interface {
+		Purchased(ctx context.Context, purchases []Purchase)
+	}
+)
@@ -0,0 +1,160 @@
+package purchases
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"learning-golang-restful-api/config"
+	"learning-golang-restful-api/products"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	C            *mongo.Client
+	db           *mongo.Database
+	coll         *mongo.Collection
+	productsColl *mongo.Collection
+	cfg          config.Properties
+	h            *PurchasesHandler
+)
+
+func init() {
+	if err := cleanenv.ReadEnv(&cfg); err != nil {
+		log.Fatalf("Configuration cannot be read : %v", err)
+	}
+
+	mongoURL := fmt.Sprintf("mongodb://%s:%s", cfg.DBHost, cfg.DBPort)
+
+	C, err := mongo.Connect(context.Background(), options.Client().ApplyURI(mongoURL))
+	if err != nil {
+		log.Fatalf("Unable to connect to database : %v", err)
+	}
+
+	db = C.Database(cfg.DBName)
+	coll = db.Collection(cfg.DBPurchasesCollection)
+	productsColl = db.Collection(cfg.DBProductCollection)
+	h = &PurchasesHandler{DefaultLimit: 2, Window: time.Hour}
+}
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+	testCode := m.Run()
+	coll.Drop(ctx)
+	db.Drop(ctx)
+	os.Exit(testCode)
+}
+
+func TestPurchases(t *testing.T) {
+	productID := primitive.NewObjectID()
+	_, err := productsColl.InsertOne(context.Background(), products.Product{
+		ID:          productID,
+		Name:        "mask",
+		Price:       5,
+		Currency:    "USD",
+		Vendor:      "3M",
+		Accessories: []string{"filter"},
+		IsEssential: true,
+	})
+	assert.Nil(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "buyer@gmail.com"})
+	body := fmt.Sprintf(`[{"product_id": "%s", "quantity": 2}]`, productID.Hex())
+
+	t.Run("Test cart reports the remaining allowance", func(t *testing.T) {
+		var allowances []Allowance
+		req := httptest.NewRequest(http.MethodPost, "/purchases/cart", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		ctx.Set("user", token)
+		h.Coll = coll
+		h.ProductsColl = productsColl
+		assert.Nil(t, h.CheckCart(ctx))
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		err := json.Unmarshal(res.Body.Bytes(), &allowances)
+		assert.Nil(t, err)
+		assert.Equal(t, []Allowance{{ProductID: productID, Name: "mask", Limit: 2, Remaining: 2, Quantity: 2}}, allowances)
+	})
+
+	t.Run("Test purchase within the limit", func(t *testing.T) {
+		var ids []string
+		req := httptest.NewRequest(http.MethodPost, "/purchases", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		ctx.Set("user", token)
+		h.Coll = coll
+		h.ProductsColl = productsColl
+		assert.Nil(t, h.CreatePurchases(ctx))
+		assert.Equal(t, http.StatusCreated, res.Code)
+
+		err := json.Unmarshal(res.Body.Bytes(), &ids)
+		assert.Nil(t, err)
+		assert.Len(t, ids, 1)
+	})
+
+	t.Run("Test purchase over the limit", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/purchases", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		ctx.Set("user", token)
+		h.Coll = coll
+		h.ProductsColl = productsColl
+		err := h.CreatePurchases(ctx)
+		assert.NotNil(t, err)
+
+		httpErr, ok := err.(*echo.HTTPError)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusUnprocessableEntity, httpErr.Code)
+		assert.Equal(t, 0, httpErr.Message.(echo.Map)["remaining"])
+	})
+
+	t.Run("Test drafts cannot be bought", func(t *testing.T) {
+		draftID := primitive.NewObjectID()
+		_, err := productsColl.InsertOne(context.Background(), products.Product{ID: draftID, Name: "gloves", Status: products.StatusDraft})
+		assert.Nil(t, err)
+
+		_, httpErr := findPurchasable(context.Background(), draftID, productsColl)
+		assert.NotNil(t, httpErr)
+		assert.Equal(t, http.StatusNotFound, httpErr.Code)
+	})
+	t.Run("Test a purchase in the next period is allowed again", func(t *testing.T) {
+		ctx := context.Background()
+		countersColl := db.Collection(cfg.DBPurchaseCountersCollection)
+		previous := time.Now().Add(-2 * h.Window)
+		_, err := coll.UpdateMany(ctx, bson.M{"user_id": "buyer@gmail.com", "product_id": productID}, bson.M{"$set": bson.M{"created_at": previous}})
+		assert.Nil(t, err)
+
+		purchases := []Purchase{{ProductID: productID, Quantity: 2}}
+		ids, httpErr := createPurchases(ctx, "buyer@gmail.com", purchases, h.DefaultLimit, h.Window, nil, nil, productsColl, countersColl, coll)
+		assert.Nil(t, httpErr)
+		assert.Len(t, ids, 1)
+
+		var counter struct {
+			ExpiresAt time.Time `bson:"expires_at"`
+		}
+		id := bson.D{{Key: "user_id", Value: "buyer@gmail.com"}, {Key: "product_id", Value: productID}}
+		assert.Nil(t, countersColl.FindOne(ctx, bson.M{"_id": id}).Decode(&counter))
+		assert.True(t, counter.ExpiresAt.After(time.Now()))
+	})
+}
@@ -0,0 +1,133 @@
+package realtime
+
+import (
+	"learning-golang-restful-api/auth"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+const (
+	// writeWait is how long a single write may take
+	writeWait = 10 * time.Second
+	// maxMessageSize caps what a client may send, subscriptions are tiny
+	maxMessageSize = 4096
+)
+
+type RealtimeHandler struct {
+	Hub *Hub
+	// PingInterval is how often the server pings, a client silent for twice as long is dropped
+	PingInterval time.Duration
+	// AllowedOrigins lists the browser origins that may open a socket, requests without an Origin are not from a browser
+	AllowedOrigins []string
+}
+
+// checkOrigin keeps other sites from opening a socket with a visitor's token
+func (h *RealtimeHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range h.AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *client) readPump(pingInterval time.Duration) {
+	defer c.close()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	})
+
+	for {
+		var msg Message
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Errorf("Unable to read from websocket: %v", err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+
+		var reply Message
+		switch msg.Action {
+		case "subscribe":
+			if !validTopic(msg.Topic) {
+				reply = Message{Type: "error", Topic: msg.Topic, Message: "Unknown topic"}
+				break
+			}
+			c.hub.subscribe(c, msg.Topic)
+			reply = Message{Type: "subscribed", Topic: msg.Topic}
+		case "unsubscribe":
+			c.hub.unsubscribe(c, msg.Topic)
+			reply = Message{Type: "unsubscribed", Topic: msg.Topic}
+		case "ping":
+			reply = Message{Type: "pong"}
+		default:
+			reply = Message{Type: "error", Message: "Unknown action"}
+		}
+
+		if !c.trySend(reply) {
+			return
+		}
+	}
+}
+
+func (c *client) writePump(pingInterval time.Duration) {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := c.conn.WriteJSON(msg); err != nil {
+				c.close()
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// Connect upgrades an authenticated request to a WebSocket and serves it until the client leaves
+func (h *RealtimeHandler) Connect(c echo.Context) error {
+	upgrader := websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024, CheckOrigin: h.checkOrigin}
+	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// Upgrade has already replied to the client
+		log.Errorf("Unable to upgrade to websocket: %v", err)
+		return nil
+	}
+
+	client := h.Hub.newClient(conn, auth.FromContext(c))
+	// the claims are read once, so the socket must not outlive the token they came from
+	if exp, ok := auth.ExpiresAt(c); ok {
+		timer := time.AfterFunc(time.Until(exp), client.close)
+		defer timer.Stop()
+	}
+	go client.writePump(h.PingInterval)
+	client.readPump(h.PingInterval)
+	return nil
+}
@@ -0,0 +1,209 @@
+package realtime
+
+import (
+	"context"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/products"
+	"learning-golang-restful-api/purchases"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// InventoryTopic carries every purchase, i.e. every stock movement
+	InventoryTopic = "inventory"
+
+	productTopicPrefix = "product:"
+	vendorTopicPrefix  = "vendor:"
+)
+
+// Message is what travels over the socket in both directions
+type Message struct {
+	// Action is set by clients: subscribe, unsubscribe or ping
+	Action string `json:"action,omitempty"`
+	// Type is set by the server: subscribed, unsubscribed, event, inventory, pong or error
+	Type    string      `json:"type,omitempty"`
+	Topic   string      `json:"topic,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+// Hub routes pushed updates to the connections subscribed to a topic
+type Hub struct {
+	// SendBuffer is how many messages a connection may queue before it is dropped as too slow
+	SendBuffer int
+
+	mu     sync.RWMutex
+	topics map[string]map[*client]struct{}
+}
+
+type client struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	claims auth.Claims
+	send   chan Message
+	// topics is guarded by hub.mu
+	topics map[string]bool
+
+	mu        sync.Mutex
+	closed    bool
+	closeOnce sync.Once
+}
+
+// trySend queues msg without blocking, false means the send buffer is full
+func (c *client) trySend(msg Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return true
+	}
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// close unsubscribes the client and stops its writer, which then closes the connection
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		c.hub.remove(c)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.closed = true
+		close(c.send)
+	})
+}
+
+// validTopic accepts product:<id>, vendor:<name> and inventory
+func validTopic(topic string) bool {
+	switch {
+	case topic == InventoryTopic:
+		return true
+	case strings.HasPrefix(topic, productTopicPrefix):
+		_, err := primitive.ObjectIDFromHex(strings.TrimPrefix(topic, productTopicPrefix))
+		return err == nil
+	case strings.HasPrefix(topic, vendorTopicPrefix):
+		return strings.TrimPrefix(topic, vendorTopicPrefix) != ""
+	}
+	return false
+}
+
+func (h *Hub) newClient(conn *websocket.Conn, claims auth.Claims) *client {
+	return &client{
+		hub:    h,
+		conn:   conn,
+		claims: claims,
+		send:   make(chan Message, h.SendBuffer),
+		topics: make(map[string]bool),
+	}
+}
+
+func (h *Hub) subscribe(c *client, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.topics == nil {
+		h.topics = make(map[string]map[*client]struct{})
+	}
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[*client]struct{})
+	}
+	h.topics[topic][c] = struct{}{}
+	c.topics[topic] = true
+}
+
+func (h *Hub) unsubscribe(c *client, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(c, topic)
+}
+
+func (h *Hub) removeLocked(c *client, topic string) {
+	delete(h.topics[topic], c)
+	if len(h.topics[topic]) == 0 {
+		delete(h.topics, topic)
+	}
+	delete(c.topics, topic)
+}
+
+// remove drops every subscription of a disconnected client
+func (h *Hub) remove(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for topic := range c.topics {
+		h.removeLocked(c, topic)
+	}
+}
+
+// broadcast queues a message built per subscriber, build returns false to skip a subscriber
+func (h *Hub) broadcast(topic string, build func(auth.Claims) (Message, bool)) {
+	h.mu.RLock()
+	var slow []*client
+	for c := range h.topics[topic] {
+		msg, ok := build(c.claims)
+		if !ok {
+			continue
+		}
+		msg.Topic = topic
+
+		if !c.trySend(msg) {
+			slow = append(slow, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	// a full send buffer means the client cannot keep up, drop it rather than block everyone else
+	for _, c := range slow {
+		c.close()
+	}
+}
+
+// PublishEvent pushes a product event to the product's and the vendor's topics
+func (h *Hub) PublishEvent(event products.Event) {
+	build := func(claims auth.Claims) (Message, bool) {
+		visible, ok := event.For(claims)
+		return Message{Type: "event", Data: visible}, ok
+	}
+
+	h.broadcast(productTopicPrefix+event.ProductID.Hex(), build)
+	if event.Vendor != "" {
+		h.broadcast(vendorTopicPrefix+event.Vendor, build)
+	}
+}
+
+// Purchased pushes purchases to the inventory topic
+func (h *Hub) Purchased(ctx context.Context, purchases []purchases.Purchase) {
+	for _, purchase := range purchases {
+		data := map[string]interface{}{
+			"product_id": purchase.ProductID,
+			"quantity":   purchase.Quantity,
+			"at":         purchase.CreatedAt,
+		}
+		h.broadcast(InventoryTopic, func(auth.Claims) (Message, bool) {
+			return Message{Type: "inventory", Data: data}, true
+		})
+	}
+}
+
+// Run forwards the broker's product events to subscribers until ctx is done
+func (h *Hub) Run(ctx context.Context, broker *products.Broker) {
+	// no backlog, only what happens from now on
+	_, events, unsubscribe := broker.Subscribe(^uint64(0))
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			h.PublishEvent(event)
+		}
+	}
+}
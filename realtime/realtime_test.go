@@ -0,0 +1,133 @@
+package realtime
+
+import (
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/products"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func dial(t *testing.T, h *RealtimeHandler) *websocket.Conn {
+	conn, _, err := dialWith(t, h, nil)
+	assert.Nil(t, err)
+	return conn
+}
+
+// dialWith connects with the given headers, middleware such as a fake JWT runs before Connect
+func dialWith(t *testing.T, h *RealtimeHandler, header http.Header, m ...echo.MiddlewareFunc) (*websocket.Conn, *http.Response, error) {
+	e := echo.New()
+	e.GET("/ws", h.Connect, m...)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		return nil, resp, err
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn, resp, nil
+}
+
+func TestRealtime(t *testing.T) {
+	t.Run("Test subscribe and receive a vendor event", func(t *testing.T) {
+		hub := &Hub{SendBuffer: 8}
+		conn := dial(t, &RealtimeHandler{Hub: hub, PingInterval: time.Minute})
+
+		var reply Message
+		assert.Nil(t, conn.WriteJSON(Message{Action: "subscribe", Topic: "vendor:Amazon"}))
+		assert.Nil(t, conn.ReadJSON(&reply))
+		assert.Equal(t, "subscribed", reply.Type)
+
+		product := products.Product{ID: primitive.NewObjectID(), Name: "alexa", Vendor: "Amazon"}
+		hub.PublishEvent(products.Event{ID: 1, Type: products.EventUpdated, ProductID: product.ID, Vendor: "Amazon", Product: &product})
+
+		var pushed Message
+		assert.Nil(t, conn.ReadJSON(&pushed))
+		assert.Equal(t, "event", pushed.Type)
+		assert.Equal(t, "vendor:Amazon", pushed.Topic)
+		assert.Equal(t, products.EventUpdated, pushed.Data.(map[string]interface{})["type"])
+	})
+
+	t.Run("Test unknown topic", func(t *testing.T) {
+		conn := dial(t, &RealtimeHandler{Hub: &Hub{SendBuffer: 8}, PingInterval: time.Minute})
+
+		var reply Message
+		assert.Nil(t, conn.WriteJSON(Message{Action: "subscribe", Topic: "product:not-an-id"}))
+		assert.Nil(t, conn.ReadJSON(&reply))
+		assert.Equal(t, "error", reply.Type)
+	})
+
+	t.Run("Test disconnect removes subscriptions", func(t *testing.T) {
+		hub := &Hub{SendBuffer: 8}
+		conn := dial(t, &RealtimeHandler{Hub: hub, PingInterval: time.Minute})
+
+		var reply Message
+		assert.Nil(t, conn.WriteJSON(Message{Action: "subscribe", Topic: InventoryTopic}))
+		assert.Nil(t, conn.ReadJSON(&reply))
+		conn.Close()
+
+		assert.Eventually(t, func() bool {
+			hub.mu.RLock()
+			defer hub.mu.RUnlock()
+			return len(hub.topics) == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Test slow client is dropped", func(t *testing.T) {
+		hub := &Hub{SendBuffer: 1}
+		c := hub.newClient(nil, auth.Claims{})
+		hub.subscribe(c, InventoryTopic)
+
+		build := func(auth.Claims) (Message, bool) {
+			return Message{Type: "inventory"}, true
+		}
+		hub.broadcast(InventoryTopic, build)
+		hub.broadcast(InventoryTopic, build)
+
+		hub.mu.RLock()
+		defer hub.mu.RUnlock()
+		_, ok := hub.topics[InventoryTopic]
+		assert.False(t, ok)
+	})
+	t.Run("Test origins outside the allow-list are refused", func(t *testing.T) {
+		h := &RealtimeHandler{Hub: &Hub{SendBuffer: 8}, PingInterval: time.Minute, AllowedOrigins: []string{"http://shop.example"}}
+
+		_, resp, err := dialWith(t, h, http.Header{"Origin": {"http://evil.example"}})
+		assert.NotNil(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		conn, _, err := dialWith(t, h, http.Header{"Origin": {"http://shop.example"}})
+		assert.Nil(t, err)
+		conn.Close()
+	})
+
+	t.Run("Test the socket closes when the token expires", func(t *testing.T) {
+		hub := &Hub{SendBuffer: 8}
+		token := func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				exp := float64(time.Now().Add(200 * time.Millisecond).Unix())
+				c.Set("user", &jwt.Token{Claims: jwt.MapClaims{"user_id": "1", "exp": exp}})
+				return next(c)
+			}
+		}
+		conn, _, err := dialWith(t, &RealtimeHandler{Hub: hub, PingInterval: time.Minute}, nil, token)
+		assert.Nil(t, err)
+
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				break
+			}
+		}
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+	})
+}
@@ -0,0 +1,87 @@
+package reports
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// defaultMaxEntries caps caches built without MaxEntries
+const defaultMaxEntries = 1000
+
+// Cache keeps report results for TTL, the zero TTL disables caching,
+// at most MaxEntries are kept and the ones closest to expiring go first
+type Cache struct {
+	TTL        time.Duration
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// Get returns the cached value for key when it has not expired
+func (c *Cache) Get(key string) (interface{}, bool) {
+	if c == nil || c.TTL <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Set stores value under key for TTL
+func (c *Cache) Set(key string, value interface{}) {
+	if c == nil || c.TTL <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]cacheEntry)
+	}
+	now := time.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries() {
+		c.evict(now)
+	}
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(c.TTL)}
+}
+
+func (c *Cache) maxEntries() int {
+	if c.MaxEntries <= 0 {
+		return defaultMaxEntries
+	}
+	return c.MaxEntries
+}
+
+// evict sweeps the expired entries, or the one closest to expiring when none has expired
+func (c *Cache) evict(now time.Time) {
+	var oldest string
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldest == "" || entry.expires.Before(c.entries[oldest].expires) {
+			oldest = key
+		}
+	}
+	if len(c.entries) >= c.maxEntries() {
+		delete(c.entries, oldest)
+	}
+}
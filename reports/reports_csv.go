@@ -0,0 +1,54 @@
+package reports
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// writeCSV writes a slice of report rows, one column per json tagged field
+func writeCSV(w io.Writer, rows interface{}) error {
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice {
+		return fmt.Errorf("rows must be a slice, got %s", value.Kind())
+	}
+
+	rowType := value.Type().Elem()
+	var header []string
+	for i := 0; i < rowType.NumField(); i++ {
+		header = append(header, strings.Split(rowType.Field(i).Tag.Get("json"), ",")[0])
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for i := 0; i < value.Len(); i++ {
+		row := value.Index(i)
+		var record []string
+		for j := 0; j < row.NumField(); j++ {
+			record = append(record, csvField(row.Field(j)))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvField(field reflect.Value) string {
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String {
+		return strings.Join(field.Interface().([]string), "|")
+	}
+	if id, ok := field.Interface().(primitive.ObjectID); ok {
+		return id.Hex()
+	}
+	return fmt.Sprint(field.Interface())
+}
@@ -0,0 +1,31 @@
+package reports
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// VendorRow describes the catalog of one vendor
+type VendorRow struct {
+	Vendor       string  `json:"vendor" bson:"_id"`
+	ProductCount int     `json:"product_count" bson:"product_count"`
+	AveragePrice float64 `json:"average_price" bson:"average_price"`
+}
+
+// DiscountRow describes how many products have a discount within [from, to)
+type DiscountRow struct {
+	From         int `json:"from" bson:"_id"`
+	To           int `json:"to" bson:"to"`
+	ProductCount int `json:"product_count" bson:"product_count"`
+}
+
+// BareProductRow describes a product that has no accessories
+type BareProductRow struct {
+	ID     primitive.ObjectID `json:"_id" bson:"_id"`
+	Name   string             `json:"name" bson:"name"`
+	Vendor string             `json:"vendor" bson:"vendor"`
+}
+
+// WeekRow describes the products added during one ISO week
+type WeekRow struct {
+	Week         string   `json:"week" bson:"_id"`
+	ProductCount int      `json:"product_count" bson:"product_count"`
+	Products     []string `json:"products" bson:"products"`
+}
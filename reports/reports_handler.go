@@ -0,0 +1,190 @@
+package reports
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ReportsHandler struct {
+	Coll  CollectionAPI
+	Cache *Cache
+}
+
+// intParam reads a positive integer query param, falling back to def when it is absent
+func intParam(qs url.Values, name string, def int) (int, *echo.HTTPError) {
+	value := qs.Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, name+" must be a positive integer")
+	}
+	return n, nil
+}
+
+func aggregate(ctx context.Context, pipeline mongo.Pipeline, rows interface{}, coll CollectionAPI) *echo.HTTPError {
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Errorf("Unable to aggregate the report: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to aggregate the report")
+	}
+
+	if err := cursor.All(ctx, rows); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to read the cursor")
+	}
+
+	return nil
+}
+
+func vendorsReport(ctx context.Context, qs url.Values, coll CollectionAPI) (interface{}, *echo.HTTPError) {
+	rows := []VendorRow{}
+
+	minProducts, httpErr := intParam(qs, "min_products", 1)
+	if httpErr != nil {
+		return rows, httpErr
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":           "$vendor",
+			"product_count": bson.M{"$sum": 1},
+			"average_price": bson.M{"$avg": "$price"},
+		}}},
+		{{Key: "$match", Value: bson.M{"product_count": bson.M{"$gte": minProducts}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "product_count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+
+	return rows, aggregate(ctx, pipeline, &rows, coll)
+}
+
+func discountsReport(ctx context.Context, qs url.Values, coll CollectionAPI) (interface{}, *echo.HTTPError) {
+	rows := []DiscountRow{}
+
+	step, httpErr := intParam(qs, "step", 10)
+	if httpErr != nil {
+		return rows, httpErr
+	}
+
+	from := bson.M{"$multiply": bson.A{
+		bson.M{"$floor": bson.M{"$divide": bson.A{bson.M{"$ifNull": bson.A{"$discount", 0}}, step}}},
+		step,
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": from, "product_count": bson.M{"$sum": 1}}}},
+		{{Key: "$addFields", Value: bson.M{"to": bson.M{"$add": bson.A{"$_id", step}}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+
+	return rows, aggregate(ctx, pipeline, &rows, coll)
+}
+
+func withoutAccessoriesReport(ctx context.Context, qs url.Values, coll CollectionAPI) (interface{}, *echo.HTTPError) {
+	rows := []BareProductRow{}
+
+	match := bson.M{"$or": bson.A{
+		bson.M{"accessories": bson.M{"$exists": false}},
+		bson.M{"accessories": bson.M{"$size": 0}},
+	}}
+	if vendor := qs.Get("vendor"); vendor != "" {
+		match["vendor"] = vendor
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$project", Value: bson.M{"name": 1, "vendor": 1}}},
+		{{Key: "$sort", Value: bson.M{"vendor": 1}}},
+	}
+
+	return rows, aggregate(ctx, pipeline, &rows, coll)
+}
+
+func newestReport(ctx context.Context, qs url.Values, coll CollectionAPI) (interface{}, *echo.HTTPError) {
+	rows := []WeekRow{}
+
+	weeks, httpErr := intParam(qs, "weeks", 4)
+	if httpErr != nil {
+		return rows, httpErr
+	}
+
+	// an ObjectID carries the moment the product was inserted
+	pipeline := mongo.Pipeline{
+		{{Key: "$addFields", Value: bson.M{"inserted_at": bson.M{"$toDate": "$_id"}}}},
+		{{Key: "$sort", Value: bson.M{"inserted_at": -1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           bson.M{"$dateToString": bson.M{"format": "%G-W%V", "date": "$inserted_at"}},
+			"product_count": bson.M{"$sum": 1},
+			"products":      bson.M{"$push": "$name"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": -1}}},
+		{{Key: "$limit", Value: weeks}},
+	}
+
+	return rows, aggregate(ctx, pipeline, &rows, coll)
+}
+
+// cacheKey identifies a report by its path and params, the output format does not change the rows
+func cacheKey(c echo.Context) string {
+	qs := url.Values{}
+	for k, v := range c.QueryParams() {
+		if k != "format" {
+			qs[k] = v
+		}
+	}
+	return c.Path() + "?" + qs.Encode()
+}
+
+func wantsCSV(c echo.Context) bool {
+	return c.QueryParam("format") == "csv" || strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/csv")
+}
+
+func (h *ReportsHandler) serve(c echo.Context, report func(context.Context, url.Values, CollectionAPI) (interface{}, *echo.HTTPError)) error {
+	key := cacheKey(c)
+	rows, ok := h.Cache.Get(key)
+	if !ok {
+		var err *echo.HTTPError
+		rows, err = report(context.Background(), c.QueryParams(), h.Coll)
+		if err != nil {
+			return err
+		}
+		h.Cache.Set(key, rows)
+	}
+
+	if wantsCSV(c) {
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=UTF-8")
+		c.Response().WriteHeader(http.StatusOK)
+		if err := writeCSV(c.Response(), rows); err != nil {
+			log.Errorf("Unable to write csv: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	return c.JSON(http.StatusOK, rows)
+}
+
+func (h *ReportsHandler) GetVendorsReport(c echo.Context) error {
+	return h.serve(c, vendorsReport)
+}
+
+func (h *ReportsHandler) GetDiscountsReport(c echo.Context) error {
+	return h.serve(c, discountsReport)
+}
+
+func (h *ReportsHandler) GetWithoutAccessoriesReport(c echo.Context) error {
+	return h.serve(c, withoutAccessoriesReport)
+}
+
+func (h *ReportsHandler) GetNewestReport(c echo.Context) error {
+	return h.serve(c, newestReport)
+}
@@ -0,0 +1,66 @@
+package reports
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	t.Run("Test cached value within ttl", func(t *testing.T) {
+		cache := &Cache{TTL: time.Minute}
+		cache.Set("/reports/vendors?", []VendorRow{{Vendor: "Amazon", ProductCount: 1}})
+
+		rows, ok := cache.Get("/reports/vendors?")
+		assert.True(t, ok)
+		assert.Equal(t, "Amazon", rows.([]VendorRow)[0].Vendor)
+	})
+
+	t.Run("Test expired value", func(t *testing.T) {
+		cache := &Cache{TTL: time.Millisecond}
+		cache.Set("/reports/vendors?", []VendorRow{})
+		time.Sleep(5 * time.Millisecond)
+
+		_, ok := cache.Get("/reports/vendors?")
+		assert.False(t, ok)
+	})
+
+	t.Run("Test full cache drops expired then oldest values", func(t *testing.T) {
+		cache := &Cache{TTL: time.Minute, MaxEntries: 2}
+		cache.Set("a", 1)
+		cache.Set("b", 2)
+		cache.Set("c", 3)
+
+		_, ok := cache.Get("a")
+		assert.False(t, ok)
+		_, ok = cache.Get("c")
+		assert.True(t, ok)
+		assert.Len(t, cache.entries, 2)
+
+		cache.TTL = time.Millisecond
+		cache.Set("b", 2)
+		time.Sleep(5 * time.Millisecond)
+		cache.Set("d", 4)
+		_, ok = cache.Get("c")
+		assert.True(t, ok)
+		assert.NotContains(t, cache.entries, "b")
+	})
+
+	t.Run("Test disabled cache", func(t *testing.T) {
+		cache := &Cache{}
+		cache.Set("/reports/vendors?", []VendorRow{})
+
+		_, ok := cache.Get("/reports/vendors?")
+		assert.False(t, ok)
+	})
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	rows := []WeekRow{{Week: "2021-W29", ProductCount: 2, Products: []string{"alexa", "echo"}}}
+
+	assert.Nil(t, writeCSV(&buf, rows))
+	assert.Equal(t, "week,product_count,products\n2021-W29,2,alexa|echo\n", buf.String())
+}
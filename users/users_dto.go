@@ -1,18 +1,28 @@
 package users
 
 import (
+	"encoding/xml"
+	"learning-golang-restful-api/problem"
+
 	"github.com/go-playground/validator"
 )
 
 var (
-	v = validator.New()
+	v = problem.NewValidator()
 )
 
 // User describes an electronic product e.g. phone
 type User struct {
-	Email    string `json:"username" bson:"username" validate:"required,email"`
-	Password string `json:"password" bson:"password" validate:"required,min=8,max=300"`
-	IsAdmin  bool   `json:"is_admin,omitempty" bson:"is_admin"`
+	Email    string `json:"username" xml:"username" bson:"username" validate:"required,email"`
+	Password string `json:"password" xml:"password,omitempty" bson:"password" validate:"required,min=8,max=300" graphql:"-"`
+	IsAdmin  bool   `json:"is_admin,omitempty" xml:"is_admin,omitempty" bson:"is_admin" graphql:"-"`
+}
+
+// MarshalXML writes a user as <user>
+func (u User) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type user User
+	start.Name.Local = "user"
+	return e.EncodeElement(user(u), start)
 }
 
 // UserValidator a product validator
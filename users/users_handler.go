@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"learning-golang-restful-api/config"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/hypermedia"
+	"learning-golang-restful-api/negotiation"
 	"net/http"
 	"time"
 
@@ -20,69 +23,99 @@ var (
 	cfg config.Properties
 )
 
+// EventRegistered is recorded in the outbox for every new user
+const EventRegistered = "user.registered"
+
 type UsersHandler struct {
-	Coll CollectionAPI
+	Coll   CollectionAPI
+	Outbox Outbox
 }
 
-func createUser(ctx context.Context, user User, coll CollectionAPI) (interface{}, *echo.HTTPError) {
+func createUser(ctx context.Context, user User, box Outbox, coll CollectionAPI) (interface{}, *domainerr.Error) {
 	var newUser User
 	findRes := coll.FindOne(ctx, bson.M{"username": user.Email})
 	err := findRes.Decode(&newUser)
 	if err != nil && err != mongo.ErrNoDocuments {
 		log.Errorf("Unable to decode retrieved user: %s", err)
-		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Unable to decode retrieved user")
+		return nil, domainerr.New(domainerr.Internal, "Unable to decode retrieved user")
 	}
 
 	if newUser.Email != "" {
 		log.Errorf("User by %s already exists", newUser.Email)
-		return nil, echo.NewHTTPError(http.StatusConflict, "User already exists")
+		return nil, domainerr.New(domainerr.Conflict, "User already exists")
 	}
 
 	pass, err := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
 	if err != nil {
 		log.Errorf("Unable to hash the password: %v", err)
-		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Unable to process the password")
+		return nil, domainerr.New(domainerr.Internal, "Unable to process the password")
 	}
 
 	user.Password = string(pass)
 
-	insertRes, err := coll.InsertOne(ctx, user)
+	if box == nil {
+		insertRes, err := coll.InsertOne(ctx, user)
+		if err != nil {
+			log.Errorf("Unable to insert: %v", err)
+			return nil, domainerr.New(domainerr.Internal, "Unable to insert")
+		}
+		return insertRes.InsertedID, nil
+	}
+
+	// the user and its registered event are stored together or not at all
+	var insertedID interface{}
+	err = box.Transact(ctx, func(ctx context.Context) error {
+		insertRes, err := coll.InsertOne(ctx, user)
+		if err != nil {
+			return err
+		}
+		insertedID = insertRes.InsertedID
+
+		registered := user
+		registered.Password = ""
+		return box.Record(ctx, EventRegistered, user.Email, registered)
+	})
 	if err != nil {
 		log.Errorf("Unable to insert: %v", err)
-		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Unable to insert")
+		return nil, domainerr.New(domainerr.Internal, "Unable to insert")
 	}
 
-	return insertRes.InsertedID, nil
+	return insertedID, nil
 }
 
 func (h *UsersHandler) RegisterUser(c echo.Context) error {
 	c.Echo().Validator = &UserValidator{validator: v}
 
 	var user User
-	if err := c.Bind(&user); err != nil {
+	if err := negotiation.Bind(c, &user); err != nil {
 		log.Errorf("Unable to bind: %v", err)
-		return c.JSON(http.StatusInternalServerError, "Unable to bind data")
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to bind data")
 	}
 
 	if err := c.Validate(user); err != nil {
 		log.Errorf("Unable to validate the user %+v %v", user, err)
-		return c.JSON(http.StatusBadRequest, "Unable to validate the user")
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to validate the user").SetInternal(err)
 	}
+	// nobody registers as an admin
+	user.IsAdmin = false
 
-	_, err := createUser(context.Background(), user, h.Coll)
+	_, err := createUser(context.Background(), user, h.Outbox, h.Coll)
 	if err != nil {
-		return c.JSON(err.Code, err.Message)
+		return err
 	}
 
 	token, tokenErr := user.createToken()
 	if tokenErr != nil {
 		log.Errorf("Unable to generate the token: %v", tokenErr)
-		return c.JSON(http.StatusInternalServerError, "Unable to generate the token")
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to generate the token")
 	}
 
 	user.Password = ""
 	c.Response().Header().Set("x-auth-token", "Bearer "+token)
-	return c.JSON(http.StatusCreated, user)
+	return hypermedia.Render(c, http.StatusCreated, userResource(user), user)
 }
 
 func isCredValid(givenPassword, hashedPassword string) bool {
@@ -112,23 +145,23 @@ func (u User) createToken() (string, error) {
 	return token, nil
 }
 
-func loginUser(ctx context.Context, user *User, coll CollectionAPI) (interface{}, *echo.HTTPError) {
+func loginUser(ctx context.Context, user *User, coll CollectionAPI) (interface{}, *domainerr.Error) {
 	givenPassword := user.Password
 
 	res := coll.FindOne(ctx, bson.M{"username": user.Email})
 	err := res.Decode(&user)
 	if err != nil && err != mongo.ErrNoDocuments {
 		log.Errorf("Unable to decode retrieved user: %s", err)
-		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Unable to decode retrieved user")
+		return nil, domainerr.New(domainerr.Internal, "Unable to decode retrieved user")
 	}
 
 	if err == mongo.ErrNoDocuments {
 		log.Errorf("Unable %s does not exists", user.Email)
-		return nil, echo.NewHTTPError(http.StatusNotFound, "User does not exists")
+		return nil, domainerr.New(domainerr.NotFound, "User does not exists")
 	}
 
 	if !isCredValid(givenPassword, user.Password) {
-		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid Credentials")
+		return nil, domainerr.New(domainerr.Unauthorized, "Invalid Credentials")
 	}
 
 	return User{Email: user.Email}, nil
@@ -138,27 +171,56 @@ func (h *UsersHandler) LoginUser(c echo.Context) error {
 	c.Echo().Validator = &UserValidator{validator: v}
 
 	var user User
-	if err := c.Bind(&user); err != nil {
+	if err := negotiation.Bind(c, &user); err != nil {
 		log.Errorf("Unable to bind: %v", err)
-		return c.JSON(http.StatusUnprocessableEntity, "Unable to bind data")
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Unable to bind data")
 	}
 
 	if err := c.Validate(user); err != nil {
 		log.Errorf("Unable to validate the user %+v %v", user, err)
-		return c.JSON(http.StatusBadRequest, "Unable to validate the payload")
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to validate the payload").SetInternal(err)
 	}
 
 	ids, err := loginUser(context.Background(), &user, h.Coll)
 	if err != nil {
-		return c.JSON(err.Code, err.Message)
+		return err
 	}
 
 	token, tokenErr := user.createToken()
 	if tokenErr != nil {
 		log.Errorf("Unable to generate the token: %v", tokenErr)
-		return c.JSON(http.StatusInternalServerError, "Unable to generate the token")
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to generate the token")
 	}
 
 	c.Response().Header().Set("x-auth-token", "Bearer "+token)
-	return c.JSON(http.StatusCreated, ids)
+	return hypermedia.Render(c, http.StatusCreated, userResource(User{Email: user.Email}), ids)
+}
+
+// findUsers reads the users with the given usernames in one query, without their passwords
+func findUsers(ctx context.Context, emails []string, coll CollectionAPI) ([]User, *domainerr.Error) {
+	users := []User{}
+
+	cursor, err := coll.Find(ctx, bson.M{"username": bson.M{"$in": emails}})
+	if err != nil {
+		log.Errorf("Unable to find users : %v", err)
+		return users, domainerr.New(domainerr.Internal, "Unable to find users")
+	}
+
+	if err := cursor.All(ctx, &users); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return users, domainerr.New(domainerr.Internal, "Unable to read the cursor")
+	}
+
+	for i := range users {
+		users[i].Password = ""
+	}
+	return users, nil
+}
+
+// FindByEmails reads many users at once for callers that batch lookups
+func (h *UsersHandler) FindByEmails(ctx context.Context, emails []string) ([]User, *domainerr.Error) {
+	return findUsers(ctx, emails, h.Coll)
 }
@@ -0,0 +1,24 @@
+package users
+
+import "learning-golang-restful-api/hypermedia"
+
+// userResource is the hypermedia form of a user, identified by username and never with the password
+func userResource(user User) hypermedia.Document {
+	return hypermedia.Document{Data: []hypermedia.Resource{{
+		Type: "users",
+		ID:   user.Email,
+		Attributes: map[string]interface{}{
+			"username": user.Email,
+			"is_admin": user.IsAdmin,
+		},
+	}}}
+}
+
+// sessionResource is the hypermedia form of a v2 login
+func sessionResource(session Session) hypermedia.Document {
+	return hypermedia.Document{Data: []hypermedia.Resource{{
+		Type:       "sessions",
+		ID:         session.Username,
+		Attributes: session,
+	}}}
+}
@@ -11,6 +11,13 @@ type (
 	// CollectionAPI collection interface
 	CollectionAPI interface {
 		InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+		Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
 		FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
 	}
+
+	// Outbox records domain events in the same transaction as the writes that cause them
+	Outbox interface {
+		Transact(ctx context.Context, fn func(ctx context.Context) error) error
+		Record(ctx context.Context, eventType string, aggregateID string, payload interface{}) error
+	}
 )
@@ -4,12 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"learning-golang-restful-api/problem"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
 
+	"github.com/golang-jwt/jwt"
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/labstack/echo"
 	"github.com/labstack/gommon/log"
@@ -64,9 +66,22 @@ func TestUsers(t *testing.T) {
 		e := echo.New()
 		ctx := e.NewContext(req, res)
 		h.Coll = coll
-		t.Logf("res: %#+v\n", res.Body.Bytes())
-		assert.Nil(t, h.RegisterUser(ctx))
-		assert.Equal(t, http.StatusBadRequest, res.Code)
+		err := h.RegisterUser(ctx)
+		assert.NotNil(t, err)
+		httpErr, ok := err.(*echo.HTTPError)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusBadRequest, httpErr.Code)
+		assert.Equal(t, []problem.FieldError{{In: "body", Field: "username", Rule: "email", Reason: "failed on email"}}, problem.Fields(httpErr.Internal))
+	})
+
+	t.Run("Test register with an empty body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/auth/register", nil)
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		ctx := echo.New().NewContext(req, res)
+		h.Coll = coll
+		err := h.RegisterUser(ctx)
+		assert.Equal(t, echo.NewHTTPError(http.StatusBadRequest, "Request body can't be empty"), err)
 	})
 
 	t.Run("Test register", func(t *testing.T) {
@@ -97,6 +112,26 @@ func TestUsers(t *testing.T) {
 			assert.Empty(t, user.Password)
 		})
 
+		t.Run("Test v2 register ignores is_admin", func(t *testing.T) {
+			body := `{"username": "adninsijawa.admin@gmail.com", "password": "password", "is_admin": true}`
+			req := httptest.NewRequest(http.MethodPost, "/v2/auth/register", strings.NewReader(body))
+			res := httptest.NewRecorder()
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			ctx := echo.New().NewContext(req, res)
+			h.Coll = coll
+			assert.Nil(t, h.RegisterUserV2(ctx))
+			assert.Equal(t, http.StatusCreated, res.Code)
+
+			claims := jwt.MapClaims{}
+			token := strings.TrimPrefix(res.Header().Get("X-Auth-Token"), "Bearer ")
+			_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+				return []byte(cfg.JwtSecret), nil
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, false, claims["authorized"])
+			assert.NotContains(t, res.Body.String(), "is_admin")
+		})
+
 		// t.Run("Test register with is_admin", func(t *testing.T) {
 		// 	var user User
 		// 	body := `
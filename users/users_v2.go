@@ -0,0 +1,92 @@
+package users
+
+import (
+	"context"
+	"encoding/xml"
+	"learning-golang-restful-api/domainerr"
+	"learning-golang-restful-api/hypermedia"
+	"learning-golang-restful-api/negotiation"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// Session is what /v2/auth/login answers with, the token is also in X-Auth-Token as in v1
+type Session struct {
+	Username string `json:"username" xml:"username"`
+	Token    string `json:"token" xml:"token"`
+}
+
+// MarshalXML writes a session as <session>
+func (s Session) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type session Session
+	start.Name.Local = "session"
+	return e.EncodeElement(session(s), start)
+}
+
+func (h *UsersHandler) RegisterUserV2(c echo.Context) error {
+	var user User
+	if err := negotiation.Bind(c, &user); err != nil {
+		log.Errorf("Unable to bind: %v", err)
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to bind data")
+	}
+
+	if err := v.Struct(user); err != nil {
+		log.Errorf("Unable to validate the user %+v %v", user, err)
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to validate the user").SetInternal(err)
+	}
+	// nobody registers as an admin
+	user.IsAdmin = false
+
+	if _, err := createUser(context.Background(), user, h.Outbox, h.Coll); err != nil {
+		return err
+	}
+
+	token, err := user.createToken()
+	if err != nil {
+		log.Errorf("Unable to generate the token: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to generate the token")
+	}
+
+	user.Password = ""
+	c.Response().Header().Set("x-auth-token", "Bearer "+token)
+	return hypermedia.Render(c, http.StatusCreated, userResource(user), user)
+}
+
+func (h *UsersHandler) LoginUserV2(c echo.Context) error {
+	var user User
+	if err := negotiation.Bind(c, &user); err != nil {
+		log.Errorf("Unable to bind: %v", err)
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to bind data")
+	}
+
+	if err := v.Struct(user); err != nil {
+		log.Errorf("Unable to validate the user %+v %v", user, err)
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to validate the payload").SetInternal(err)
+	}
+
+	if _, err := loginUser(context.Background(), &user, h.Coll); err != nil {
+		// unknown users and wrong passwords look the same, v1 tells them apart
+		if err.Kind == domainerr.NotFound || err.Kind == domainerr.Unauthorized {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid Credentials")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to log in")
+	}
+
+	token, err := user.createToken()
+	if err != nil {
+		log.Errorf("Unable to generate the token: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to generate the token")
+	}
+
+	c.Response().Header().Set("x-auth-token", "Bearer "+token)
+	session := Session{Username: user.Email, Token: "Bearer " + token}
+	return hypermedia.Render(c, http.StatusOK, sessionResource(session), session)
+}
@@ -0,0 +1,174 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Dispatcher fans events out to the registered webhooks and keeps the delivery log,
+// deliveries wait in the log until Run sends them so the ones pending at a restart are sent after it
+type Dispatcher struct {
+	Coll           CollectionAPI
+	DeliveriesColl CollectionAPI
+	Client         *http.Client
+	MaxAttempts    int
+	Backoff        time.Duration
+	// Lock is how long a claimed delivery is left to its sender before another one may take it
+	Lock time.Duration
+}
+
+// Dispatch records a pending delivery for every active webhook subscribed to event, an error means
+// not every delivery could be recorded. Deliveries are recorded once per event id and webhook,
+// so dispatching an event again only records the ones that are missing
+func (d *Dispatcher) Dispatch(ctx context.Context, eventID string, event string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Errorf("Unable to encode the %s payload: %v", event, err)
+		return err
+	}
+
+	var hooks []Webhook
+	cursor, err := d.Coll.Find(ctx, bson.M{"active": true, "events": event})
+	if err != nil {
+		log.Errorf("Unable to find webhooks : %v", err)
+		return err
+	}
+	if err := cursor.All(ctx, &hooks); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return err
+	}
+
+	for _, hook := range hooks {
+		delivery := newDelivery(hook, event, string(body), time.Now().UTC())
+		delivery.EventID = eventID
+		if _, err := d.record(ctx, delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newDelivery(hook Webhook, event string, payload string, now time.Time) Delivery {
+	return Delivery{
+		ID:            primitive.NewObjectID(),
+		WebhookID:     hook.ID,
+		Event:         event,
+		Payload:       payload,
+		Status:        DeliveryPending,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// record inserts a pending delivery into the log, a delivery already recorded for its event and webhook is kept
+func (d *Dispatcher) record(ctx context.Context, delivery Delivery) (Delivery, error) {
+	if _, err := d.DeliveriesColl.InsertOne(ctx, delivery); err != nil {
+		if delivery.EventID != "" && mongo.IsDuplicateKeyError(err) {
+			return delivery, nil
+		}
+		log.Errorf("Unable to insert the delivery: %v", err)
+		return delivery, err
+	}
+	return delivery, nil
+}
+
+// Run sends the deliveries that are due every interval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.deliverDue(ctx)
+		}
+	}
+}
+
+// dueFilter matches the pending deliveries whose next attempt has come, deliveries logged
+// before next_attempt_at existed have none and are due
+func dueFilter(now time.Time) bson.M {
+	return bson.M{
+		"status": DeliveryPending,
+		"$or": bson.A{
+			bson.M{"next_attempt_at": bson.M{"$lte": now}},
+			bson.M{"next_attempt_at": bson.M{"$exists": false}},
+		},
+	}
+}
+
+// deliverDue claims the due deliveries one at a time and attempts them, a claim pushes next_attempt_at
+// past Lock so other instances leave the delivery alone while it is sent
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	lock := d.Lock
+	if lock <= 0 {
+		lock = 30 * time.Second
+	}
+
+	for {
+		now := time.Now().UTC()
+		var delivery Delivery
+		err := d.DeliveriesColl.FindOneAndUpdate(ctx, dueFilter(now),
+			bson.M{"$set": bson.M{"next_attempt_at": now.Add(lock)}},
+			options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetReturnDocument(options.After),
+		).Decode(&delivery)
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			log.Errorf("Unable to claim a delivery: %v", err)
+			return
+		}
+
+		d.attempt(ctx, delivery)
+	}
+}
+
+// attempt sends a claimed delivery once and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, delivery Delivery) {
+	client := d.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	var hook Webhook
+	code, err := 0, d.Coll.FindOne(ctx, bson.M{"_id": delivery.WebhookID}).Decode(&hook)
+	if err == nil {
+		code, err = send(ctx, client, hook, delivery, time.Now())
+	}
+
+	update := attemptUpdate(delivery.Attempts+1, d.MaxAttempts, d.Backoff, code, err, time.Now().UTC())
+	if _, uErr := d.DeliveriesColl.UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{"$set": update}); uErr != nil {
+		log.Errorf("Unable to update the delivery: %v", uErr)
+	}
+	if err != nil {
+		log.Errorf("Webhook delivery %s to %s failed: %v", delivery.ID.Hex(), hook.URL, err)
+	}
+}
+
+// attemptUpdate is the $set recording attempt n, after a failure the next attempt waits backoff doubled per attempt
+func attemptUpdate(n int, maxAttempts int, backoff time.Duration, code int, err error, now time.Time) bson.M {
+	update := bson.M{"attempts": n, "response_code": code, "updated_at": now, "last_error": "", "next_attempt_at": nil}
+	switch {
+	case err == nil:
+		update["status"] = DeliverySucceeded
+	case n >= maxAttempts:
+		update["status"] = DeliveryFailed
+		update["last_error"] = err.Error()
+	default:
+		update["last_error"] = err.Error()
+		update["next_attempt_at"] = now.Add(backoff << (n - 1))
+	}
+	return update
+}
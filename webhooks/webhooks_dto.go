@@ -0,0 +1,63 @@
+package webhooks
+
+import (
+	"learning-golang-restful-api/problem"
+	"time"
+
+	"github.com/go-playground/validator"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	v = problem.NewValidator()
+)
+
+const (
+	EventProductCreated = "product.created"
+	EventProductUpdated = "product.updated"
+	EventProductDeleted = "product.deleted"
+	EventUserRegistered = "user.registered"
+
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook describes an endpoint that receives signed events
+type Webhook struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	URL       string             `json:"url" bson:"url" validate:"required,url"`
+	Secret    string             `json:"secret,omitempty" bson:"secret"`
+	Events    []string           `json:"events" bson:"events" validate:"required,min=1,dive,oneof=product.created product.updated product.deleted user.registered"`
+	Active    bool               `json:"active" bson:"active"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Delivery describes one event sent to one webhook, with every attempt recorded
+type Delivery struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	WebhookID primitive.ObjectID `json:"webhook_id" bson:"webhook_id"`
+	// EventID is the outbox event the delivery was dispatched for, replays have none
+	EventID      string              `json:"event_id,omitempty" bson:"event_id,omitempty"`
+	Event        string              `json:"event" bson:"event"`
+	Payload      string              `json:"payload" bson:"payload"`
+	Status       string              `json:"status" bson:"status"`
+	Attempts     int                 `json:"attempts" bson:"attempts"`
+	ResponseCode int                 `json:"response_code,omitempty" bson:"response_code,omitempty"`
+	LastError    string              `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	ReplayOf     *primitive.ObjectID `json:"replay_of,omitempty" bson:"replay_of,omitempty"`
+	// NextAttemptAt is when a pending delivery is sent next
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty" bson:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" bson:"updated_at"`
+}
+
+// WebhookValidator a webhook validator
+type WebhookValidator struct {
+	validator *validator.Validate
+}
+
+// Validates a webhook
+func (v *WebhookValidator) Validate(i interface{}) error {
+	return v.validator.Struct(i)
+}
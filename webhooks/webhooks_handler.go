@@ -0,0 +1,207 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type WebhooksHandler struct {
+	Coll           CollectionAPI
+	DeliveriesColl CollectionAPI
+	Dispatcher     *Dispatcher
+}
+
+// newSecret returns a random signing secret for a webhook registered without one
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func createWebhook(ctx context.Context, hook Webhook, coll CollectionAPI) (Webhook, *echo.HTTPError) {
+	hook.ID = primitive.NewObjectID()
+	hook.Active = true
+	hook.CreatedAt = time.Now().UTC()
+	if hook.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			log.Errorf("Unable to generate the secret: %v", err)
+			return hook, echo.NewHTTPError(http.StatusInternalServerError, "Unable to generate the secret")
+		}
+		hook.Secret = secret
+	}
+
+	if _, err := coll.InsertOne(ctx, hook); err != nil {
+		log.Errorf("Unable to insert: %v", err)
+		return hook, echo.NewHTTPError(http.StatusInternalServerError, "Unable to insert")
+	}
+
+	return hook, nil
+}
+
+func (h *WebhooksHandler) CreateWebhook(c echo.Context) error {
+	c.Echo().Validator = &WebhookValidator{validator: v}
+
+	var hook Webhook
+	if err := c.Bind(&hook); err != nil {
+		log.Errorf("Unable to bind: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to bind data")
+	}
+
+	if err := c.Validate(hook); err != nil {
+		log.Errorf("Unable to validate the webhook %+v %v", hook, err)
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to validate the webhook").SetInternal(err)
+	}
+
+	hook, err := createWebhook(context.Background(), hook, h.Coll)
+	if err != nil {
+		return err
+	}
+
+	// the secret is only ever shown here
+	return c.JSON(http.StatusCreated, hook)
+}
+
+func findWebhooks(ctx context.Context, coll CollectionAPI) ([]Webhook, *echo.HTTPError) {
+	hooks := []Webhook{}
+
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		log.Errorf("Unable to find webhooks : %v", err)
+		return hooks, echo.NewHTTPError(http.StatusInternalServerError, "Unable to find webhooks")
+	}
+
+	if err := cursor.All(ctx, &hooks); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return hooks, echo.NewHTTPError(http.StatusInternalServerError, "Unable to read the cursor")
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return hooks, nil
+}
+
+func (h *WebhooksHandler) GetWebhooks(c echo.Context) error {
+	hooks, err := findWebhooks(context.Background(), h.Coll)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &hooks)
+}
+
+func deleteWebhook(ctx context.Context, id string, coll CollectionAPI) (int64, *echo.HTTPError) {
+	_id, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Errorf("Unable to convert id to _id: %v", err)
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Unable to convert id to _id")
+	}
+
+	res, err := coll.DeleteOne(ctx, bson.M{"_id": _id})
+	if err != nil {
+		log.Errorf("Unable to delete the webhook : %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Unable to delete the webhook")
+	}
+
+	return res.DeletedCount, nil
+}
+
+func (h *WebhooksHandler) DeleteWebhook(c echo.Context) error {
+	delCount, err := deleteWebhook(context.Background(), c.Param("id"), h.Coll)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, delCount)
+}
+
+func findDeliveries(ctx context.Context, id string, coll CollectionAPI) ([]Delivery, *echo.HTTPError) {
+	deliveries := []Delivery{}
+
+	_id, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Errorf("Unable to convert id to _id: %v", err)
+		return deliveries, echo.NewHTTPError(http.StatusBadRequest, "Unable to convert id to _id")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := coll.Find(ctx, bson.M{"webhook_id": _id}, opts)
+	if err != nil {
+		log.Errorf("Unable to find deliveries : %v", err)
+		return deliveries, echo.NewHTTPError(http.StatusInternalServerError, "Unable to find deliveries")
+	}
+
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return deliveries, echo.NewHTTPError(http.StatusInternalServerError, "Unable to read the cursor")
+	}
+
+	return deliveries, nil
+}
+
+func (h *WebhooksHandler) GetDeliveries(c echo.Context) error {
+	deliveries, err := findDeliveries(context.Background(), c.Param("id"), h.DeliveriesColl)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &deliveries)
+}
+
+// replayDelivery sends a logged delivery again as a new delivery that points back at the original
+func replayDelivery(ctx context.Context, id string, dispatcher *Dispatcher) (Delivery, *echo.HTTPError) {
+	var original Delivery
+
+	_id, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Errorf("Unable to convert id to _id: %v", err)
+		return original, echo.NewHTTPError(http.StatusBadRequest, "Unable to convert id to _id")
+	}
+
+	if err := dispatcher.DeliveriesColl.FindOne(ctx, bson.M{"_id": _id}).Decode(&original); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return original, echo.NewHTTPError(http.StatusNotFound, "Delivery does not exists")
+		}
+		log.Errorf("Unable to decode FindOne res: %v", err)
+		return original, echo.NewHTTPError(http.StatusInternalServerError, "Unable to decode FindOne res")
+	}
+
+	var hook Webhook
+	if err := dispatcher.Coll.FindOne(ctx, bson.M{"_id": original.WebhookID}).Decode(&hook); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return original, echo.NewHTTPError(http.StatusNotFound, "Webhook does not exists")
+		}
+		log.Errorf("Unable to decode FindOne res: %v", err)
+		return original, echo.NewHTTPError(http.StatusInternalServerError, "Unable to decode FindOne res")
+	}
+
+	delivery := newDelivery(hook, original.Event, original.Payload, time.Now().UTC())
+	delivery.ReplayOf = &original.ID
+	if _, err := dispatcher.record(ctx, delivery); err != nil {
+		return delivery, echo.NewHTTPError(http.StatusInternalServerError, "Unable to insert the delivery")
+	}
+
+	return delivery, nil
+}
+
+func (h *WebhooksHandler) ReplayDelivery(c echo.Context) error {
+	delivery, err := replayDelivery(context.Background(), c.Param("id"), h.Dispatcher)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusAccepted, delivery)
+}
@@ -0,0 +1,114 @@
+package webhooks
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	hook := Webhook{ID: primitive.NewObjectID(), Secret: "s3cret"}
+	delivery := Delivery{ID: primitive.NewObjectID(), Event: EventProductCreated, Payload: `{"type":"product.created"}`}
+
+	t.Run("Test delivery is signed over timestamp and body", func(t *testing.T) {
+		var header http.Header
+		var body []byte
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			body, _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer receiver.Close()
+
+		hook := hook
+		hook.URL = receiver.URL
+		now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		code, err := send(context.Background(), receiver.Client(), hook, delivery, now)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, code)
+
+		assert.Equal(t, delivery.Payload, string(body))
+		assert.Equal(t, EventProductCreated, header.Get(HeaderEvent))
+		assert.Equal(t, delivery.ID.Hex(), header.Get(HeaderDelivery))
+
+		parts := strings.Split(header.Get(HeaderSignature), ",")
+		require.Len(t, parts, 2)
+		ts, err := strconv.ParseInt(strings.TrimPrefix(parts[0], "t="), 10, 64)
+		require.NoError(t, err)
+		assert.Equal(t, now.Unix(), ts)
+		assert.Equal(t, "v1="+Sign("s3cret", ts, body), parts[1])
+		assert.NotEqual(t, Sign("other", ts, body), Sign("s3cret", ts, body))
+	})
+
+	t.Run("Test non 2xx response is an error", func(t *testing.T) {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer receiver.Close()
+
+		hook := hook
+		hook.URL = receiver.URL
+		code, err := send(context.Background(), receiver.Client(), hook, delivery, time.Now())
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusBadGateway, code)
+	})
+
+	t.Run("Test failed attempts wait twice as long each time", func(t *testing.T) {
+		now := time.Now().UTC()
+		first := attemptUpdate(1, 3, time.Second, http.StatusServiceUnavailable, assert.AnError, now)
+		second := attemptUpdate(2, 3, time.Second, http.StatusServiceUnavailable, assert.AnError, now)
+		assert.Nil(t, first["status"])
+		assert.Equal(t, now.Add(time.Second), first["next_attempt_at"])
+		assert.Equal(t, now.Add(2*time.Second), second["next_attempt_at"])
+	})
+
+	t.Run("Test the last attempt fails the delivery", func(t *testing.T) {
+		update := attemptUpdate(3, 3, time.Second, 0, assert.AnError, time.Now())
+		assert.Equal(t, DeliveryFailed, update["status"])
+		assert.Nil(t, update["next_attempt_at"])
+		assert.Equal(t, assert.AnError.Error(), update["last_error"])
+	})
+
+	t.Run("Test a sent delivery succeeds", func(t *testing.T) {
+		update := attemptUpdate(2, 3, time.Second, http.StatusOK, nil, time.Now())
+		assert.Equal(t, DeliverySucceeded, update["status"])
+		assert.Nil(t, update["next_attempt_at"])
+	})
+
+	t.Run("Test an event is recorded once per webhook", func(t *testing.T) {
+		d := &Dispatcher{DeliveriesColl: duplicates{}}
+		redispatched := newDelivery(hook, EventProductCreated, delivery.Payload, time.Now())
+		redispatched.EventID = primitive.NewObjectID().Hex()
+		_, err := d.record(context.Background(), redispatched)
+		assert.NoError(t, err)
+
+		_, err = d.record(context.Background(), newDelivery(hook, EventProductCreated, delivery.Payload, time.Now()))
+		assert.Error(t, err)
+	})
+
+	t.Run("Test only pending deliveries that are due are claimed", func(t *testing.T) {
+		filter := dueFilter(time.Now())
+		assert.Equal(t, DeliveryPending, filter["status"])
+		assert.Len(t, filter["$or"], 2)
+	})
+}
+
+// duplicates is a deliveries collection where every delivery was already recorded
+type duplicates struct {
+	CollectionAPI
+}
+
+func (duplicates) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	return nil, mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "duplicate key"}}}
+}
@@ -0,0 +1,46 @@
+package wishlists
+
+import (
+	"learning-golang-restful-api/problem"
+	"time"
+
+	"github.com/go-playground/validator"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	v = problem.NewValidator()
+)
+
+// Wishlist describes a named list of products kept by a user
+type Wishlist struct {
+	ID         primitive.ObjectID   `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID     string               `json:"user_id,omitempty" bson:"user_id"`
+	Name       string               `json:"name" bson:"name" validate:"required,max=50"`
+	ProductIDs []primitive.ObjectID `json:"product_ids" bson:"product_ids"`
+	Shared     bool                 `json:"shared" bson:"shared"`
+	ShareToken string               `json:"share_token,omitempty" bson:"share_token,omitempty"`
+}
+
+// Notification describes an in-app message for a user
+type Notification struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    string             `json:"user_id" bson:"user_id"`
+	Type      string             `json:"type" bson:"type"`
+	ProductID primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Message   string             `json:"message" bson:"message"`
+	OldPrice  int                `json:"old_price" bson:"old_price"`
+	NewPrice  int                `json:"new_price" bson:"new_price"`
+	Read      bool               `json:"read" bson:"read"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// WishlistValidator a wishlist validator
+type WishlistValidator struct {
+	validator *validator.Validate
+}
+
+// Validates a wishlist
+func (v *WishlistValidator) Validate(i interface{}) error {
+	return v.validator.Struct(i)
+}
@@ -0,0 +1,251 @@
+package wishlists
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"learning-golang-restful-api/auth"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type WishlistsHandler struct {
+	Coll              CollectionAPI
+	NotificationsColl CollectionAPI
+}
+
+// newShareToken returns an unguessable token for a shared wishlist link
+func newShareToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// applySharing keeps the share token in line with the shared flag
+func applySharing(wishlist *Wishlist) *echo.HTTPError {
+	if !wishlist.Shared {
+		wishlist.ShareToken = ""
+		return nil
+	}
+
+	if wishlist.ShareToken != "" {
+		return nil
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		log.Errorf("Unable to generate the share token: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to generate the share token")
+	}
+	wishlist.ShareToken = token
+	return nil
+}
+
+func createWishlist(ctx context.Context, userID string, wishlist Wishlist, coll CollectionAPI) (interface{}, *echo.HTTPError) {
+	wishlist.ID = primitive.NewObjectID()
+	wishlist.UserID = userID
+	wishlist.ShareToken = ""
+	if wishlist.ProductIDs == nil {
+		wishlist.ProductIDs = []primitive.ObjectID{}
+	}
+	if err := applySharing(&wishlist); err != nil {
+		return nil, err
+	}
+
+	res, err := coll.InsertOne(ctx, wishlist)
+	if err != nil {
+		log.Errorf("Unable to insert: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Unable to insert")
+	}
+
+	return res.InsertedID, nil
+}
+
+func (h *WishlistsHandler) CreateWishlist(c echo.Context) error {
+	c.Echo().Validator = &WishlistValidator{validator: v}
+
+	var wishlist Wishlist
+	if err := c.Bind(&wishlist); err != nil {
+		log.Errorf("Unable to bind: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to bind data")
+	}
+
+	if err := c.Validate(wishlist); err != nil {
+		log.Errorf("Unable to validate the wishlist %+v %v", wishlist, err)
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to validate the wishlist").SetInternal(err)
+	}
+
+	id, err := createWishlist(context.Background(), auth.FromContext(c).UserID, wishlist, h.Coll)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, id)
+}
+
+func findWishlists(ctx context.Context, userID string, coll CollectionAPI) ([]Wishlist, *echo.HTTPError) {
+	wishlists := []Wishlist{}
+
+	cursor, err := coll.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		log.Errorf("Unable to find wishlists : %v", err)
+		return wishlists, echo.NewHTTPError(http.StatusInternalServerError, "Unable to find wishlists")
+	}
+
+	if err := cursor.All(ctx, &wishlists); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return wishlists, echo.NewHTTPError(http.StatusInternalServerError, "Unable to read the cursor")
+	}
+
+	return wishlists, nil
+}
+
+func (h *WishlistsHandler) GetWishlists(c echo.Context) error {
+	wishlists, err := findWishlists(context.Background(), auth.FromContext(c).UserID, h.Coll)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &wishlists)
+}
+
+func findWishlist(ctx context.Context, userID string, id string, coll CollectionAPI) (*Wishlist, *echo.HTTPError) {
+	var wishlist Wishlist
+
+	_id, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Errorf("Unable to convert id to _id: %v", err)
+		return &wishlist, echo.NewHTTPError(http.StatusBadRequest, "Unable to convert id to _id")
+	}
+
+	res := coll.FindOne(ctx, bson.M{"_id": _id, "user_id": userID})
+	if err := res.Decode(&wishlist); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return &wishlist, echo.NewHTTPError(http.StatusNotFound, "Wishlist does not exists")
+		}
+		log.Errorf("Unable to decode FindOne res: %v", err)
+		return &wishlist, echo.NewHTTPError(http.StatusInternalServerError, "Unable to decode FindOne res")
+	}
+
+	return &wishlist, nil
+}
+
+func (h *WishlistsHandler) GetWishlist(c echo.Context) error {
+	wishlist, err := findWishlist(context.Background(), auth.FromContext(c).UserID, c.Param("id"), h.Coll)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, wishlist)
+}
+
+func findSharedWishlist(ctx context.Context, token string, coll CollectionAPI) (*Wishlist, *echo.HTTPError) {
+	var wishlist Wishlist
+
+	res := coll.FindOne(ctx, bson.M{"share_token": token, "shared": true})
+	if err := res.Decode(&wishlist); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return &wishlist, echo.NewHTTPError(http.StatusNotFound, "Wishlist does not exists")
+		}
+		log.Errorf("Unable to decode FindOne res: %v", err)
+		return &wishlist, echo.NewHTTPError(http.StatusInternalServerError, "Unable to decode FindOne res")
+	}
+
+	// the link is public, so the owner and the token stay private
+	wishlist.UserID = ""
+	wishlist.ShareToken = ""
+	return &wishlist, nil
+}
+
+func (h *WishlistsHandler) GetSharedWishlist(c echo.Context) error {
+	wishlist, err := findSharedWishlist(context.Background(), c.Param("token"), h.Coll)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, wishlist)
+}
+
+func updateWishlist(ctx context.Context, userID string, id string, body io.ReadCloser, coll CollectionAPI) (*Wishlist, *echo.HTTPError) {
+	// 1. find the caller's wishlist or return 404
+	wishlist, httpErr := findWishlist(ctx, userID, id, coll)
+	if httpErr != nil {
+		return wishlist, httpErr
+	}
+	wishlistID, token := wishlist.ID, wishlist.ShareToken
+
+	// 2. decode body to struct or return 400
+	if err := json.NewDecoder(body).Decode(wishlist); err != nil {
+		log.Errorf("Unable to decode from req body to struct: %v", err)
+		return wishlist, echo.NewHTTPError(http.StatusBadRequest, "Unable to decode from req body to struct")
+	}
+
+	// 3. validate decoded body or return 400
+	if err := v.Struct(wishlist); err != nil {
+		log.Errorf("Unable to validate the wishlist: %v", err)
+		return wishlist, echo.NewHTTPError(http.StatusBadRequest, "Unable to validate the wishlist").SetInternal(err)
+	}
+
+	// id, owner and share token are managed by the server
+	wishlist.ID = wishlistID
+	wishlist.UserID = userID
+	wishlist.ShareToken = token
+	if wishlist.ProductIDs == nil {
+		wishlist.ProductIDs = []primitive.ObjectID{}
+	}
+	if httpErr := applySharing(wishlist); httpErr != nil {
+		return wishlist, httpErr
+	}
+
+	// 4. update data or return 500
+	_, err := coll.UpdateOne(ctx, bson.M{"_id": wishlist.ID, "user_id": userID}, bson.M{"$set": wishlist})
+	if err != nil {
+		log.Errorf("Unable to update: %v", err)
+		return wishlist, echo.NewHTTPError(http.StatusInternalServerError, "Unable to update")
+	}
+
+	return wishlist, nil
+}
+
+func (h *WishlistsHandler) UpdateWishlist(c echo.Context) error {
+	wishlist, err := updateWishlist(context.Background(), auth.FromContext(c).UserID, c.Param("id"), c.Request().Body, h.Coll)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, wishlist)
+}
+
+func deleteWishlist(ctx context.Context, userID string, id string, coll CollectionAPI) (int, *echo.HTTPError) {
+	_id, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Errorf("Unable to convert id to _id: %v", err)
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Unable to convert id to _id")
+	}
+
+	res, err := coll.DeleteOne(ctx, bson.M{"_id": _id, "user_id": userID})
+	if err != nil {
+		log.Errorf("Unable to delete data: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Unable to delete data")
+	}
+
+	return int(res.DeletedCount), nil
+}
+
+func (h *WishlistsHandler) DeleteWishlist(c echo.Context) error {
+	del, err := deleteWishlist(context.Background(), auth.FromContext(c).UserID, c.Param("id"), h.Coll)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, del)
+}
@@ -0,0 +1,81 @@
+package wishlists
+
+import (
+	"context"
+	"fmt"
+	"learning-golang-restful-api/auth"
+	"learning-golang-restful-api/products"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const priceDropNotification = "price_drop"
+
+// PriceDropped creates a notification for every user watching the product, once per user
+func (h *WishlistsHandler) PriceDropped(ctx context.Context, product products.Product, oldPrice int) error {
+	cursor, err := h.Coll.Find(ctx, bson.M{"product_ids": product.ID})
+	if err != nil {
+		return fmt.Errorf("unable to find watchers: %w", err)
+	}
+
+	var wishlists []Wishlist
+	if err := cursor.All(ctx, &wishlists); err != nil {
+		return fmt.Errorf("unable to read the cursor: %w", err)
+	}
+
+	notified := make(map[string]bool)
+	now := time.Now()
+	for _, wishlist := range wishlists {
+		if notified[wishlist.UserID] {
+			continue
+		}
+		notified[wishlist.UserID] = true
+
+		notification := Notification{
+			UserID:    wishlist.UserID,
+			Type:      priceDropNotification,
+			ProductID: product.ID,
+			Message:   fmt.Sprintf("%s dropped from %d to %d %s", product.Name, oldPrice, product.EffectivePrice(), product.Currency),
+			OldPrice:  oldPrice,
+			NewPrice:  product.EffectivePrice(),
+			CreatedAt: now,
+		}
+		if _, err := h.NotificationsColl.InsertOne(ctx, notification); err != nil {
+			return fmt.Errorf("unable to insert notification: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func findNotifications(ctx context.Context, userID string, coll CollectionAPI) ([]Notification, *echo.HTTPError) {
+	notifications := []Notification{}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := coll.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		log.Errorf("Unable to find notifications : %v", err)
+		return notifications, echo.NewHTTPError(http.StatusInternalServerError, "Unable to find notifications")
+	}
+
+	if err := cursor.All(ctx, &notifications); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return notifications, echo.NewHTTPError(http.StatusInternalServerError, "Unable to read the cursor")
+	}
+
+	return notifications, nil
+}
+
+func (h *WishlistsHandler) GetNotifications(c echo.Context) error {
+	notifications, err := findNotifications(context.Background(), auth.FromContext(c).UserID, h.NotificationsColl)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &notifications)
+}
@@ -0,0 +1,162 @@
+package wishlists
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"learning-golang-restful-api/config"
+	"learning-golang-restful-api/products"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	C         *mongo.Client
+	db        *mongo.Database
+	coll      *mongo.Collection
+	notifColl *mongo.Collection
+	cfg       config.Properties
+	h         *WishlistsHandler
+)
+
+func init() {
+	if err := cleanenv.ReadEnv(&cfg); err != nil {
+		log.Fatalf("Configuration cannot be read : %v", err)
+	}
+
+	mongoURL := fmt.Sprintf("mongodb://%s:%s", cfg.DBHost, cfg.DBPort)
+
+	C, err := mongo.Connect(context.Background(), options.Client().ApplyURI(mongoURL))
+	if err != nil {
+		log.Fatalf("Unable to connect to database : %v", err)
+	}
+
+	db = C.Database(cfg.DBName)
+	coll = db.Collection(cfg.DBWishlistsCollection)
+	notifColl = db.Collection(cfg.DBNotificationsCollection)
+	h = &WishlistsHandler{}
+}
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+	testCode := m.Run()
+	coll.Drop(ctx)
+	notifColl.Drop(ctx)
+	db.Drop(ctx)
+	os.Exit(testCode)
+}
+
+func TestWishlists(t *testing.T) {
+	productID := primitive.NewObjectID()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "watcher@gmail.com"})
+	var shareToken string
+
+	t.Run("Test create a shared wishlist", func(t *testing.T) {
+		var id string
+		body := fmt.Sprintf(`{"name": "gifts", "product_ids": ["%s"], "shared": true}`, productID.Hex())
+		req := httptest.NewRequest(http.MethodPost, "/wishlists", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		ctx.Set("user", token)
+		h.Coll = coll
+		assert.Nil(t, h.CreateWishlist(ctx))
+		assert.Equal(t, http.StatusCreated, res.Code)
+
+		err := json.Unmarshal(res.Body.Bytes(), &id)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, id)
+	})
+
+	t.Run("Test get wishlists", func(t *testing.T) {
+		var wishlists []Wishlist
+		req := httptest.NewRequest(http.MethodGet, "/wishlists", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		ctx.Set("user", token)
+		h.Coll = coll
+		assert.Nil(t, h.GetWishlists(ctx))
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		err := json.Unmarshal(res.Body.Bytes(), &wishlists)
+		assert.Nil(t, err)
+		assert.Len(t, wishlists, 1)
+		assert.Len(t, wishlists[0].ShareToken, 32)
+		shareToken = wishlists[0].ShareToken
+	})
+
+	t.Run("Test get a shared wishlist", func(t *testing.T) {
+		var wishlist Wishlist
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/wishlists/shared/%s", shareToken), nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		ctx.SetParamNames("token")
+		ctx.SetParamValues(shareToken)
+		h.Coll = coll
+		assert.Nil(t, h.GetSharedWishlist(ctx))
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		err := json.Unmarshal(res.Body.Bytes(), &wishlist)
+		assert.Nil(t, err)
+		assert.Equal(t, "gifts", wishlist.Name)
+		assert.Empty(t, wishlist.UserID)
+	})
+
+	t.Run("Test price drop notification", func(t *testing.T) {
+		var notifications []Notification
+		h.Coll = coll
+		h.NotificationsColl = notifColl
+		product := products.Product{ID: productID, Name: "alexa", Price: 200, Currency: "USD"}
+		assert.Nil(t, h.PriceDropped(context.Background(), product, 250))
+
+		req := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		ctx := e.NewContext(req, res)
+		ctx.Set("user", token)
+		assert.Nil(t, h.GetNotifications(ctx))
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		err := json.Unmarshal(res.Body.Bytes(), &notifications)
+		assert.Nil(t, err)
+		assert.Len(t, notifications, 1)
+		assert.Equal(t, 200, notifications[0].NewPrice)
+	})
+	t.Run("Test put a wishlist with the _id of another", func(t *testing.T) {
+		ctx := context.Background()
+		first := Wishlist{ID: primitive.NewObjectID(), UserID: "owner@gmail.com", Name: "first", ProductIDs: []primitive.ObjectID{}}
+		second := Wishlist{ID: primitive.NewObjectID(), UserID: "owner@gmail.com", Name: "second", ProductIDs: []primitive.ObjectID{}}
+		_, err := coll.InsertOne(ctx, first)
+		assert.Nil(t, err)
+		_, err = coll.InsertOne(ctx, second)
+		assert.Nil(t, err)
+
+		body := fmt.Sprintf(`{"_id": "%s", "name": "renamed"}`, second.ID.Hex())
+		updated, httpErr := updateWishlist(ctx, "owner@gmail.com", first.ID.Hex(), ioutil.NopCloser(strings.NewReader(body)), coll)
+		assert.Nil(t, httpErr)
+		assert.Equal(t, first.ID, updated.ID)
+
+		var stored Wishlist
+		assert.Nil(t, coll.FindOne(ctx, bson.M{"_id": second.ID}).Decode(&stored))
+		assert.Equal(t, "second", stored.Name)
+		assert.Nil(t, coll.FindOne(ctx, bson.M{"_id": first.ID}).Decode(&stored))
+		assert.Equal(t, "renamed", stored.Name)
+	})
+}